@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"bosun.org/opentsdb"
+)
+
+//A pre or post action is a command that CloudBerry runs before or after a plan, typically a script to stop a
+//database or take a snapshot. Pre actions can stop the backup if they fail, and post actions can be told to
+//run even if the backup failed.
+type cbbAction struct {
+	Name        string //pre or post
+	Enabled     bool
+	CommandLine string
+	Arguments   string
+	Timeout     int  //Seconds
+	OnFailure   bool //TerminateOnFailure for pre actions, RunOnBackupFailure for post actions
+}
+
+//Get the pre and post actions of a plan
+func (p cbbBasePlan) actions() []cbbAction {
+	return []cbbAction{
+		{"pre", cbbBool(p.EnabledPreActions), strings.TrimSpace(p.CommandLine), strings.TrimSpace(p.Arguments), cbbInt(p.Timeout), cbbBool(p.TerminateOnFailure)},
+		{"post", cbbBool(p.EnabledPostActions), strings.TrimSpace(p.CommandLinePostActions), strings.TrimSpace(p.ArgumentsPostActions), cbbInt(p.TimeoutPostActions), cbbBool(p.RunOnBackupFailure)},
+	}
+}
+
+//Check that the executable for an action exists. The command line may or may not be quoted, and may be a bare
+//program name that lives somewhere on the PATH.
+func (a cbbAction) executableExists() bool {
+	command := strings.Trim(a.CommandLine, "\" ")
+	if command == "" {
+		return false
+	}
+	_, err := exec.LookPath(command)
+	return err == nil
+}
+
+//A short fingerprint of the full command that the action runs. It is sent as a number so that a change to the
+//command shows up as a change in the value of the series in Bosun.
+func (a cbbAction) commandHash() uint32 {
+	sum := sha256.Sum256([]byte(a.CommandLine + "\x00" + a.Arguments))
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+//Send the pre and post action configuration for a plan
+func sendPlanActions(plan cbbBasePlan) {
+	for _, a := range plan.actions() {
+		tags := func() opentsdb.TagSet { return opentsdb.TagSet{"job": plan.Name, "action": a.Name} }
+
+		bosunDataPoint("cloudberry.plan.actions.enabled", boolToInt(a.Enabled), tags())
+		if !a.Enabled {
+			continue
+		}
+		bosunDataPoint("cloudberry.plan.actions.executable_exists", boolToInt(a.executableExists()), tags())
+		bosunDataPoint("cloudberry.plan.actions.timeout", a.Timeout, tags())
+		bosunDataPoint("cloudberry.plan.actions.on_failure", boolToInt(a.OnFailure), tags())
+		bosunDataPoint("cloudberry.plan.actions.command_hash", a.commandHash(), tags())
+
+		bosunMetadata("cloudberry.plan.actions.enabled", "command_line", a.CommandLine, tags())
+		bosunMetadata("cloudberry.plan.actions.enabled", "arguments", a.Arguments, tags())
+		bosunMetadata("cloudberry.plan.actions.command_hash", "hash", fmt.Sprintf("%08x", a.commandHash()), tags())
+	}
+}
+
+//Bosun wants numbers, not booleans
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
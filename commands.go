@@ -0,0 +1,239 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+//Subcommands for admins troubleshooting from a shell, so they don't have to open the CloudBerry database by hand.
+//Their output is for people, not scollector.
+var commands = []struct {
+	Name string
+	Desc string
+	Run  func(args []string) error
+}{
+	{"plans", "List every plan that was found, with its schedule, paths, destination and last result", plansCommand},
+	{"sessions", "List the recent runs of a plan (-plan name or ID)", sessionsCommand},
+	{"files", "List the files in a single run of a plan (-session ID)", filesCommand},
+	{"report", "Report on every plan's runs over a date range, as JSON, CSV or HTML (-from, -to, -format)", reportCommand},
+	{"bosun-rules", "Write Bosun alert rules for the plans, with staleness thresholds from each plan's schedule", bosunRulesCommand},
+	{"dashboard", "Write a Grafana dashboard for the metrics (-datasource opentsdb, prometheus or influxdb)", dashboardCommand},
+	{"explain", "Explain what a collection would send to scollector and why, without sending anything", explainCommand},
+}
+
+//Run the named subcommand
+func runCommand(name string, args []string) error {
+	for _, c := range commands {
+		if c.Name == name {
+			return c.Run(args)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Usage: %s [flags] (collect metrics for scollector)\n   or: %s <command> [flags]\n\nCommands:\n", os.Args[0], os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.Name, c.Desc)
+	}
+	if name == "help" {
+		return nil
+	}
+	return fmt.Errorf("unknown command %q", name)
+}
+
+//Get a set of flags for a subcommand, with the flags that every subcommand needs already on it
+func newCommandFlags(name string) (*flag.FlagSet, *bool) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&CBProgramData, "data", CBProgramData, "Path to the CloudBerry ProgramData folder, which holds the .cbb plan files and cbbackup.db.")
+	asJSON := fs.Bool("json", false, "Write the output as JSON rather than a table.")
+	return fs, asJSON
+}
+
+//Find the plans and open the database, ready for a subcommand
+func openForCommand() (*sql.DB, error) {
+	if err := discoverCloudBerry(); err != nil {
+		return nil, err
+	}
+	return openCloudBerryDB()
+}
+
+//What the plans command shows for each plan
+type planSummary struct {
+	ID          string
+	Name        string
+	Type        string
+	Schedule    string
+	Paths       []string
+	Destination string
+	LastResult  string `json:",omitempty"`
+	LastStart   string `json:",omitempty"`
+}
+
+func plansCommand(args []string) error {
+	fs, asJSON := newCommandFlags("plans")
+	fs.Parse(args)
+
+	db, err := openForCommand()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var summaries []planSummary
+	for _, plan := range append(append([]cbbBasePlan{}, cbbPlansBackups...), cbbPlansConsistency...) {
+		summary := planSummary{
+			ID:          plan.ID,
+			Name:        plan.Name,
+			Type:        plan.Type,
+			Schedule:    plan.schedule().summary(),
+			Paths:       plan.Path,
+			Destination: plan.ConnectionID,
+		}
+		sessions, err := planSessions(db, plan.ID, 1)
+		if err != nil {
+			return err
+		}
+		if len(sessions) > 0 {
+			summary.LastResult = cbbResultName(sessions[0].Result)
+			summary.LastStart = formatCbbTime(sessions[0].DateStartUtc)
+		}
+		summaries = append(summaries, summary)
+	}
+
+	if *asJSON {
+		return writeJSON(summaries)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTYPE\tSCHEDULE\tPATHS\tDESTINATION\tLAST RESULT\tLAST START (UTC)")
+	for _, s := range summaries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", s.Name, s.Type, s.Schedule, strings.Join(s.Paths, "; "), s.Destination, s.LastResult, s.LastStart)
+	}
+	return w.Flush()
+}
+
+func sessionsCommand(args []string) error {
+	fs, asJSON := newCommandFlags("sessions")
+	planFlag := fs.String("plan", "", "Name or ID of the plan to list the runs of.")
+	limit := fs.Int("limit", 20, "The number of runs to list, newest first.")
+	fs.Parse(args)
+
+	if *planFlag == "" {
+		return fmt.Errorf("sessions needs a plan, e.g. sessions -plan %q", "My Backup Plan")
+	}
+
+	db, err := openForCommand()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	plan, ok := findPlan(*planFlag)
+	if !ok {
+		return fmt.Errorf("no plan with the name or ID %q", *planFlag)
+	}
+	sessions, err := planSessions(db, plan.ID, *limit)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return writeJSON(sessions)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTART (UTC)\tDURATION\tRESULT\tUPLOADED\tUPLOADED SIZE\tTOTAL SIZE\tFAILED\tERROR")
+	for _, s := range sessions {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\t%s\t%s\t%d\t%s\n", s.ID, formatCbbTime(s.DateStartUtc), time.Duration(s.Duration)*time.Second,
+			cbbResultName(s.Result), s.UploadedCount, formatBytes(float64(s.UploadedSize)), formatBytes(float64(s.TotalSize)), s.FailedCount, s.ErrorMessage)
+	}
+	return w.Flush()
+}
+
+func filesCommand(args []string) error {
+	fs, asJSON := newCommandFlags("files")
+	sessionFlag := fs.Int("session", 0, "ID of the run to list the files of, from the sessions command.")
+	limit := fs.Int("limit", 100, "The number of files to list, in the order they finished. 0 lists every file.")
+	fs.Parse(args)
+
+	if *sessionFlag == 0 {
+		return fmt.Errorf("files needs a session ID, e.g. files -session 1234")
+	}
+
+	db, err := openForCommand()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	session, err := sessionByID(db, *sessionFlag)
+	if err != nil {
+		return err
+	}
+
+	//Stream the files rather than loading them all, as a session can have millions of them
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	enc := json.NewEncoder(os.Stdout)
+	if !*asJSON {
+		fmt.Fprintln(w, "PATH\tOPERATION\tSIZE\tDURATION\tFINISHED (UTC)\tATTEMPTS\tMESSAGE")
+	}
+	err = eachSessionFileLimit(db, session.PlanID, session.ID, *limit, func(f cbbHistoryRow) {
+		if *asJSON {
+			enc.Encode(f)
+			return
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n", f.LocalPath, cbbOperationName(f.Operation), formatBytes(float64(f.Size)),
+			time.Duration(f.Duration)*time.Second, formatCbbTime(f.DateFinishedUtc), f.Attempts, f.Message)
+	})
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+//Find a backup plan by its ID, or failing that by its name
+func findPlan(nameOrID string) (cbbBasePlan, bool) {
+	for _, plan := range cbbPlansBackups {
+		if plan.ID == nameOrID {
+			return plan, true
+		}
+	}
+	for _, plan := range cbbPlansBackups {
+		if strings.EqualFold(plan.Name, nameOrID) {
+			return plan, true
+		}
+	}
+	return cbbBasePlan{}, false
+}
+
+//Write something to stdout as indented JSON
+func writeJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+//Format a CloudBerry time for a person to read, or leave it as it is if we can't read it
+func formatCbbTime(cbbTime string) string {
+	t, err := cbbTimeToTime(cbbTime)
+	if err != nil {
+		return cbbTime
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+//Format a number of bytes for a person to read, e.g. 1.5 GB
+func formatBytes(b float64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB", "PB"}
+	i := 0
+	for b >= 1024 && i < len(units)-1 {
+		b /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", b, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", b, units[i])
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//Command line options. scollector runs external collectors without passing any arguments, so every option here
+//needs a default that gives sensible output on a standard CloudBerry install.
+var (
+	suspiciousExtensionsFlag = flag.String("suspicious-extensions", ".locked,.encrypted,.enc,.crypt,.crypto,.cerber,.locky,.zepto,.wncry", "Comma separated list of file extensions that are counted as suspicious (possible ransomware) when they are seen in a backup session.")
+	sessionHistoryFlag       = flag.Int("session-history", 1000, "The number of past sessions of each plan to look at when working out trends such as the time since the last full backup.")
+	fullRatioFlag            = flag.Float64("full-ratio", 0.9, "A session that uploads at least this fraction of the total backup size is counted as a full backup. For plans that compress their data, it is this fraction of the largest upload in the session history instead.")
+	stateDirFlag             = flag.String("state", defaultStateDir(), "Directory where the collector keeps what it needs to remember between runs, such as plan snapshots for spotting configuration changes.")
+	debugFlag                = flag.Bool("debug", false, "Write the parsed plans to stderr as JSON, with secrets redacted.")
+	productionPlansFlag      = flag.String("production-plans", ".*", "Regular expression matching the names of production plans, which must send a notification when they fail.")
+	defaultRetentionFlag     = flag.Duration("default-retention", 30*24*time.Hour, "How long plans that use the default retention settings keep old versions for, used when estimating destination usage.")
+	pricesFlag               = flag.String("prices", "", "JSON file with the storage prices per GB-month to estimate costs with. The Amazon S3 list prices are used if this isn't given. If the file can't be read, costs aren't sent.")
+	formatFlag               = flag.String("format", "opentsdb", "The format to write metrics in: opentsdb (JSON for scollector), influx (InfluxDB line protocol, for Telegraf), graphite, graphite-tagged or statsd.")
+	sendFlag                 = flag.String("send", "", "Send the metrics to tcp://host:port or udp://host:port instead of writing them to stdout, e.g. tcp://graphite:2003.")
+	graphiteTagsFlag         = flag.String("graphite-tags", "host,job", "Comma separated order of the tag values in graphite and statsd paths. Tags that aren't listed come after these, in alphabetical order.")
+	pushFlag                 = flag.String("push", "", "URL of an OpenTSDB or Bosun server to send metrics straight to, e.g. http://bosun:8070, for hosts that don't run scollector.")
+	otlpFlag                 = flag.String("otlp", "", "URL of an OTLP/HTTP receiver to send metrics to as OpenTelemetry metrics, e.g. http://otel-collector:4318.")
+	metricVersionFlag        = flag.Int("metric-version", defaultMetricVersion, "The version of the metric names that dashboards and alerts were built on. Metrics that have been renamed since then are sent under their old names as well as their new ones. Set this to the current version to only send the new names.")
+	topFilesFlag             = flag.Int("top-files", 5, "The number of largest and slowest files to report for the last session of each plan.")
+)
+
+func init() {
+	flag.StringVar(&CBProgramData, "data", CBProgramData, "Path to the CloudBerry ProgramData folder, which holds the .cbb plan files and cbbackup.db.")
+}
+
+//The state directory can't live next to the collector, as scollector tries to run every file in the collectors
+//folder, so it goes in ProgramData with everything else.
+func defaultStateDir() string {
+	if programData := os.Getenv("ProgramData"); programData != "" {
+		return filepath.Join(programData, "scollector-cloudberry")
+	}
+	return filepath.Join(os.TempDir(), "scollector-cloudberry")
+}
+
+//Split a comma separated list of file extensions from the command line in to a lookup table. Extensions are
+//lower cased and given a leading period if they don't already have one, so that ".LOCKED" and "locked" are
+//treated the same.
+func parseExtensionList(list string) map[string]bool {
+	extensions := make(map[string]bool)
+	for _, ext := range strings.Split(list, ",") {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		extensions[ext] = true
+	}
+	return extensions
+}
+
+//Split a comma separated list of tag names from the command line
+func parseTagOrder(list string) []string {
+	var tags []string
+	for _, tag := range strings.Split(list, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
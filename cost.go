@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"bosun.org/opentsdb"
+)
+
+//Prices for storing data, per GB (2^30 bytes) per month. Plans are priced by the provider that their destination
+//is on and the storage class that they write to. Destinations that aren't listed use the "default" provider, and
+//storage classes that a provider doesn't have a price for aren't costed.
+type priceTable struct {
+	Currency     string                        //Sent as the unit of the cost metrics
+	Destinations map[string]string             //Provider of each destination (plan ConnectionID)
+	Prices       map[string]map[string]float64 //Price per GB-month, by provider and then storage class
+}
+
+//The prices that are used if no price table is given, which are the Amazon S3 list prices (us-east-1)
+var defaultPriceTable = priceTable{
+	Currency: "USD",
+	Prices: map[string]map[string]float64{
+		"default": {
+			"standard":           0.023,
+			"standard_ia":        0.0125,
+			"reduced_redundancy": 0.024,
+			"archive":            0.004,
+		},
+	},
+}
+
+//Load a price table from a JSON file, or get the default prices if no file is given. A file that can't be read, or
+//that doesn't say what currency it is in, is an error rather than falling back to the default prices, as that would
+//publish costs in the wrong currency. For example:
+//
+//	{
+//	  "Currency": "GBP",
+//	  "Destinations": {"5b2f6d4e-...": "azure"},
+//	  "Prices": {
+//	    "default": {"standard": 0.018, "standard_ia": 0.01, "reduced_redundancy": 0.019, "archive": 0.0035},
+//	    "azure": {"standard": 0.015, "archive": 0.0016}
+//	  }
+//	}
+func loadPriceTable(path string) (priceTable, error) {
+	if path == "" {
+		return defaultPriceTable, nil
+	}
+	var t priceTable
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return t, err
+	}
+	if err := json.Unmarshal(b, &t); err != nil {
+		return priceTable{}, fmt.Errorf("could not read the price table %s: %v", path, err)
+	}
+	if t.Currency == "" || len(t.Prices) == 0 {
+		return priceTable{}, fmt.Errorf("the price table %s needs a Currency and some Prices", path)
+	}
+	return t, nil
+}
+
+//Get the price per GB-month for a destination and storage class
+func (t priceTable) price(destination string, storageClass string) (float64, bool) {
+	provider, ok := t.Destinations[destination]
+	if !ok {
+		provider = "default"
+	}
+	price, ok := t.Prices[provider][storageClass]
+	return price, ok
+}
+
+//Estimate what a plan costs to store for a month
+func (t priceTable) monthlyCost(plan cbbBasePlan, usage planUsage) (float64, bool) {
+	price, ok := t.price(planDestination(plan), planStorageClass(plan))
+	if !ok {
+		return 0, false
+	}
+	return usage.StoredEstimate() / (1 << 30) * price, true
+}
+
+//Send the estimated cost of a plan. Returns the cost so that it can be added to its destination's cost.
+func sendPlanCost(t priceTable, plan cbbBasePlan, usage planUsage) float64 {
+	cost, ok := t.monthlyCost(plan, usage)
+	if !ok {
+		explainf("  No price for storage class %s on destination %s, so the plan isn't costed", planStorageClass(plan), planDestination(plan))
+		return 0
+	}
+	tags := opentsdb.TagSet{"job": plan.Name, "destination": planDestination(plan)}
+	bosunDataPoint("cloudberry.cost.estimated_monthly", cost, tags)
+	bosunMetadata("cloudberry.cost.estimated_monthly", "currency", t.Currency, tags)
+	bosunMetadata("cloudberry.cost.estimated_monthly", "storage_class", planStorageClass(plan), tags)
+	return cost
+}
+
+//Send the estimated cost of every destination
+func sendDestinationCosts(t priceTable, costs map[string]float64) {
+	var destinations []string
+	for d := range costs {
+		destinations = append(destinations, d)
+	}
+	sort.Strings(destinations)
+
+	for _, d := range destinations {
+		bosunDataPoint("cloudberry.cost.destination_estimated_monthly", costs[d], opentsdb.TagSet{"destination": d})
+		bosunMetadata("cloudberry.cost.destination_estimated_monthly", "currency", t.Currency, opentsdb.TagSet{"destination": d})
+	}
+}
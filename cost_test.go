@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadPriceTable(t *testing.T) {
+	if p, err := loadPriceTable(""); err != nil || p.Currency != "USD" {
+		t.Errorf("no file should give the default prices, got %+v, %v", p, err)
+	}
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"good.json":        `{"Currency": "GBP", "Destinations": {"conn-1": "azure"}, "Prices": {"default": {"standard": 0.018}, "azure": {"archive": 0.0016}}}`,
+		"truncated.json":   `{"Currency": "GBP", "Prices": {"default": {"stan`,
+		"no-currency.json": `{"Prices": {"default": {"standard": 0.018}}}`,
+		"no-prices.json":   `{"Currency": "GBP"}`,
+	}
+	for name, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p, err := loadPriceTable(filepath.Join(dir, "good.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if price, ok := p.price("conn-1", "archive"); !ok || price != 0.0016 || p.Currency != "GBP" {
+		t.Errorf("conn-1 archive should be 0.0016 GBP, got %v %v %s", price, ok, p.Currency)
+	}
+	if price, ok := p.price("conn-2", "standard"); !ok || price != 0.018 {
+		t.Errorf("other destinations should use the default provider, got %v %v", price, ok)
+	}
+	if _, ok := p.price("conn-1", "standard"); ok {
+		t.Error("azure has no standard price, so it shouldn't be costed")
+	}
+
+	for _, name := range []string{"missing.json", "truncated.json", "no-currency.json", "no-prices.json"} {
+		if p, err := loadPriceTable(filepath.Join(dir, name)); err == nil || p.Currency != "" {
+			t.Errorf("%s: expected an error and no prices, got %+v, %v", name, p, err)
+		}
+	}
+}
+
+//A price table that can't be loaded means no costs at all, not costs in the default currency
+func TestCollectWithoutPrices(t *testing.T) {
+	programData := writeTestProgramData(t, time.Now())
+	out, errs := useTestCollector(t, programData, t.TempDir())
+	previousPrices := *pricesFlag
+	*pricesFlag = filepath.Join(t.TempDir(), "missing.json")
+	t.Cleanup(func() { *pricesFlag = previousPrices })
+
+	if err := collect(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(errs.String(), "not estimating costs") {
+		t.Errorf("the missing price table wasn't reported: %q", errs.String())
+	}
+	sentUsage := false
+	for _, dp := range out.points {
+		if strings.HasPrefix(dp.Metric, "cloudberry.cost.") {
+			t.Errorf("sent %s without a price table", dp.Metric)
+		}
+		sentUsage = sentUsage || dp.Metric == "cloudberry.destination.size_total"
+	}
+	if !sentUsage {
+		t.Error("the destination usage should still be sent")
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"bosun.org/metadata"
+)
+
+//Write out a Grafana dashboard for the metrics that the collector sends. The metric names, units and tags all come
+//from the metadata and the output formats, so the dashboard always matches what the collector is sending.
+func dashboardCommand(args []string) error {
+	fs, _ := newCommandFlags("dashboard")
+	datasource := fs.String("datasource", "opentsdb", "The type of Grafana datasource the metrics are in: opentsdb, prometheus or influxdb.")
+	title := fs.String("title", "CloudBerry Backup", "The title of the dashboard.")
+	fs.Parse(args)
+
+	q, ok := dashboardQueries[strings.ToLower(*datasource)]
+	if !ok {
+		return fmt.Errorf("unknown datasource %q, expected opentsdb, prometheus or influxdb", *datasource)
+	}
+	return writeJSON(newDashboard(*title, strings.ToLower(*datasource), q))
+}
+
+//How to query a metric in each type of datasource
+type dashboardQuery struct {
+	target    func(metric string, groupBy string, refID string, label string) map[string]interface{} //label goes before the tag value in the series name
+	hostQuery string                                                                                 //Finds the hosts for the host variable
+	allValue  string                                                                                 //What the host variable is when all hosts are selected
+}
+
+var dashboardQueries = map[string]dashboardQuery{
+	"opentsdb": {
+		target: func(metric string, groupBy string, refID string, label string) map[string]interface{} {
+			return map[string]interface{}{
+				"refId":      refID,
+				"metric":     metric,
+				"aggregator": "max",
+				"alias":      label + "$tag_" + groupBy,
+				"filters": []map[string]interface{}{
+					{"type": "wildcard", "tagk": "host", "filter": "$host", "groupBy": false},
+					{"type": "wildcard", "tagk": groupBy, "filter": "*", "groupBy": true},
+				},
+			}
+		},
+		hostQuery: "tag_values(cloudberry.job.count, host)",
+		allValue:  "*",
+	},
+	"prometheus": {
+		target: func(metric string, groupBy string, refID string, label string) map[string]interface{} {
+			return map[string]interface{}{
+				"refId":        refID,
+				"expr":         fmt.Sprintf(`max by (%s) (%s{host_name=~"$host"})`, prometheusLabel(groupBy), prometheusName(metric)),
+				"legendFormat": label + "{{" + prometheusLabel(groupBy) + "}}",
+			}
+		},
+		hostQuery: "label_values(" + prometheusName("cloudberry.job.count") + ", host_name)",
+		allValue:  ".*",
+	},
+	"influxdb": {
+		target: func(metric string, groupBy string, refID string, label string) map[string]interface{} {
+			measurement, field := influxName(metric)
+			return map[string]interface{}{
+				"refId":    refID,
+				"rawQuery": true,
+				"query": fmt.Sprintf(`SELECT max("%s") FROM "%s" WHERE "host" =~ /^$host$/ AND $timeFilter GROUP BY time($__interval), "%s" fill(none)`,
+					field, measurement, groupBy),
+				"alias": label + "$tag_" + groupBy,
+			}
+		},
+		hostQuery: `SHOW TAG VALUES FROM "cloudberry_job" WITH KEY = "host"`,
+		allValue:  ".*",
+	},
+}
+
+//The name that a metric has in Prometheus when it gets there through OpenTelemetry: dots become underscores, the
+//OTLP unit goes on the end, and counters end in _total. The host is the host.name resource attribute, which becomes
+//the host_name label when the collector is set to turn resource attributes in to labels.
+func prometheusName(metric string) string {
+	name := strings.Replace(metric, ".", "_", -1)
+	meta := registry[metric]
+	unit := otlpUnit(meta.Unit)
+	suffix := prometheusUnitSuffixes[unit]
+	//Gauges without a unit are ratios. Units in braces are only annotations, so they don't get a suffix.
+	if unit == "1" && meta.Rate != metadata.Counter {
+		suffix = "ratio"
+	}
+	if suffix != "" && !strings.HasSuffix(name, "_"+suffix) {
+		name += "_" + suffix
+	}
+	if meta.Rate == metadata.Counter && !strings.HasSuffix(name, "_total") {
+		name += "_total"
+	}
+	return name
+}
+
+//The suffix that the OpenTelemetry Collector's Prometheus exporters add for each OTLP unit
+var prometheusUnitSuffixes = map[string]string{
+	"By":   "bytes",
+	"By/s": "bytes_per_second",
+	"s":    "seconds",
+	"%":    "percent",
+}
+
+//The label that a tag has in Prometheus when it gets there through OpenTelemetry
+func prometheusLabel(tag string) string {
+	return strings.Replace(otlpAttributeName(tag), ".", "_", -1)
+}
+
+//The Grafana unit for each unit in the metadata
+var grafanaUnits = map[metadata.Unit]string{
+	metadata.Bytes:          "bytes",
+	metadata.BytesPerSecond: "Bps",
+	metadata.Second:         "s",
+	metadata.Pct:            "percent",
+	metadata.Timestamp:      "dateTimeAsIso",
+	metadata.Bool:           "bool",
+	unitFraction:            "percentunit",
+}
+
+//The panels on the dashboard. Each of them shows one or more metrics, one series for each value of the group by tag.
+var dashboardPanels = []struct {
+	Title   string
+	Type    string
+	GroupBy string
+	Metrics []string
+	Width   int
+}{
+	{"Job status", "table", "job", []string{"cloudberry.job.status", "cloudberry.job.consecutive_failures", "cloudberry.job.time_since_last_success", "cloudberry.job.running"}, 24},
+	{"Job duration", "timeseries", "job", []string{"cloudberry.job.job_duration"}, 12},
+	{"Size uploaded", "timeseries", "job", []string{"cloudberry.job.size_uploaded"}, 12},
+	{"Total size", "timeseries", "job", []string{"cloudberry.job.size_total"}, 12},
+	{"Time since last success", "heatmap", "job", []string{"cloudberry.job.time_since_last_success"}, 12},
+	{"Stored on each destination (estimate)", "timeseries", "destination", []string{"cloudberry.destination.size_stored_estimate"}, 12},
+	{"Monthly cost of each destination (estimate)", "timeseries", "destination", []string{"cloudberry.cost.destination_estimated_monthly"}, 12},
+}
+
+//Build the dashboard for a type of datasource
+func newDashboard(title string, datasource string, q dashboardQuery) map[string]interface{} {
+	ds := map[string]interface{}{"type": datasource, "uid": "${datasource}"}
+
+	var panels []map[string]interface{}
+	x, y := 0, 0
+	for i, p := range dashboardPanels {
+		var targets []map[string]interface{}
+		for j, metric := range p.Metrics {
+			//When there is more than one metric on a panel, say which one each series is
+			var label string
+			if len(p.Metrics) > 1 {
+				label = metric[strings.LastIndex(metric, ".")+1:] + " "
+			}
+			targets = append(targets, q.target(metric, p.GroupBy, string(rune('A'+j)), label))
+		}
+		unit, ok := grafanaUnits[registry[p.Metrics[0]].Unit]
+		if !ok {
+			unit = "short"
+		}
+
+		panel := map[string]interface{}{
+			"id":          i + 1,
+			"title":       p.Title,
+			"type":        p.Type,
+			"datasource":  ds,
+			"targets":     targets,
+			"description": registry[p.Metrics[0]].Desc,
+			"gridPos":     map[string]int{"x": x, "y": y, "w": p.Width, "h": 8},
+			"fieldConfig": map[string]interface{}{"defaults": map[string]interface{}{"unit": unit}, "overrides": []interface{}{}},
+		}
+		//The table shows the latest value of each series, one row per series
+		if p.Type == "table" {
+			panel["transformations"] = []map[string]interface{}{
+				{"id": "reduce", "options": map[string]interface{}{"reducers": []string{"lastNotNull"}}},
+			}
+			panel["description"] = "The latest status, failures in a row, time since the last success and whether it's running for each job."
+		}
+		panels = append(panels, panel)
+
+		x += p.Width
+		if x >= 24 {
+			x = 0
+			y += 8
+		}
+	}
+
+	return map[string]interface{}{
+		"title":         title,
+		"uid":           "scollector-cloudberry-" + datasource,
+		"tags":          []string{"cloudberry", "backup"},
+		"timezone":      "browser",
+		"schemaVersion": 36,
+		"refresh":       "5m",
+		"time":          map[string]string{"from": "now-7d", "to": "now"},
+		"panels":        panels,
+		"templating": map[string]interface{}{
+			"list": []map[string]interface{}{
+				{"name": "datasource", "label": "Datasource", "type": "datasource", "query": datasource},
+				{
+					"name":       "host",
+					"label":      "Host",
+					"type":       "query",
+					"datasource": ds,
+					"query":      q.hostQuery,
+					"refresh":    2,
+					"multi":      true,
+					"includeAll": true,
+					"allValue":   q.allValue,
+					"current":    map[string]interface{}{"text": "All", "value": "$__all"},
+				},
+			},
+		},
+	}
+}
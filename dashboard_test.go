@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+//The Prometheus name of every metric on the dashboard has to match the unit and type it's sent to OTLP with, or the
+//panels come up empty
+func TestPrometheusNameMatchesOTLP(t *testing.T) {
+	//What the OpenTelemetry Collector's Prometheus exporters add for each OTLP unit, on gauges and on sums
+	gaugeSuffixes := map[string]string{"By": "_bytes", "By/s": "_bytes_per_second", "s": "_seconds", "%": "_percent", "1": "_ratio"}
+	sumSuffixes := map[string]string{"By": "_bytes_total", "By/s": "_bytes_per_second_total", "s": "_seconds_total", "%": "_percent_total", "1": "_total"}
+
+	metrics := []string{"cloudberry.job.count"} //Used by the host variable
+	for _, p := range dashboardPanels {
+		metrics = append(metrics, p.Metrics...)
+	}
+	o := &otlpOutput{byName: map[string]*otlpMetric{}}
+	for _, metric := range metrics {
+		if _, ok := registry[metric]; !ok {
+			t.Errorf("%s is on the dashboard but isn't in the registry", metric)
+			continue
+		}
+		m := o.metric(metric)
+		suffixes := gaugeSuffixes
+		if m.Sum != nil {
+			suffixes = sumSuffixes
+		}
+		suffix, ok := suffixes[m.Unit]
+		if !ok {
+			//Annotations such as {count} don't get a suffix
+			if !strings.HasPrefix(m.Unit, "{") {
+				t.Errorf("%s has an OTLP unit the test doesn't know about: %q", metric, m.Unit)
+				continue
+			}
+			if m.Sum != nil {
+				suffix = "_total"
+			}
+		}
+		want := strings.Replace(metric, ".", "_", -1)
+		if !strings.HasSuffix(want, suffix) {
+			want += suffix
+		}
+		if got := prometheusName(metric); got != want {
+			t.Errorf("prometheusName(%q) = %q, but it's sent to OTLP with unit %q so it should be %q", metric, got, m.Unit, want)
+		}
+	}
+}
+
+func TestPrometheusDashboardGroupsByPlan(t *testing.T) {
+	q := dashboardQueries["prometheus"]
+	target := q.target("cloudberry.job.job_duration", "job", "A", "")
+	if expr := target["expr"].(string); !strings.HasPrefix(expr, "max by (cloudberry_plan) (cloudberry_job_job_duration_seconds{") {
+		t.Errorf("the plan should be grouped by its OTLP attribute, not the job label: %s", expr)
+	}
+	if legend := target["legendFormat"]; legend != "{{cloudberry_plan}}" {
+		t.Errorf("legendFormat = %v", legend)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/kisielk/sqlstruct"
+)
+
+//Get the most recent session history records (one record per run of a backup job) for a plan, newest first.
+func planSessions(db *sql.DB, planID string, limit int) ([]cbbSessionHistoryRow, error) {
+	var sessions []cbbSessionHistoryRow
+
+	//Using the sqlstruct package here because the field names in the database are not valid GoLang field names. There are struct tags to map the GoLang name
+	//to the SQL field name
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM session_history WHERE plan_id = ? ORDER BY id DESC LIMIT ?`, sqlstruct.Columns(cbbSessionHistoryRow{}))
+	rows, err := db.Query(sqlStatement, planID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var session cbbSessionHistoryRow
+		if err := sqlstruct.Scan(&session, rows); err != nil {
+			return sessions, err
+		}
+		sessions = append(sessions, session)
+	}
+	explainf("  SQL: %s [%s, %d] found %d rows", sqlStatement, planID, limit, len(sessions))
+	return sessions, rows.Err()
+}
+
+//Call fn for every session of a plan, newest first. The start times are in whatever format that version of
+//CloudBerry wrote them in, so they can't be compared in SQL. Anyone who wants a date range has to read them all and
+//check the times themselves.
+func eachPlanSession(db *sql.DB, planID string, fn func(cbbSessionHistoryRow)) error {
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM session_history WHERE plan_id = ? ORDER BY id DESC`, sqlstruct.Columns(cbbSessionHistoryRow{}))
+	rows, err := db.Query(sqlStatement, planID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		var session cbbSessionHistoryRow
+		if err := sqlstruct.Scan(&session, rows); err != nil {
+			return err
+		}
+		fn(session)
+		count++
+	}
+	explainf("  SQL: %s [%s] found %d rows", sqlStatement, planID, count)
+	return rows.Err()
+}
+
+//Call fn for every history record (a single file operation) that was written during a session. A session can
+//touch millions of files, so the rows are handed over one at a time rather than being loaded in to memory.
+func eachSessionFile(db *sql.DB, planID string, sessionID int, fn func(cbbHistoryRow)) error {
+	return eachSessionFileLimit(db, planID, sessionID, 0, fn)
+}
+
+//The same as eachSessionFile, for only the first limit files in the order that they finished. A limit of 0 is every
+//file. The limit is applied in the query, so the rest of a large session is never read.
+func eachSessionFileLimit(db *sql.DB, planID string, sessionID int, limit int, fn func(cbbHistoryRow)) error {
+	if limit <= 0 {
+		limit = -1 //No limit, as far as SQLite is concerned
+	}
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM history WHERE plan_id = ? AND session_id = ? ORDER BY date_finished_utc ASC LIMIT ?`, sqlstruct.Columns(cbbHistoryRow{}))
+	rows, err := db.Query(sqlStatement, planID, sessionID, limit)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		var file cbbHistoryRow
+		if err := sqlstruct.Scan(&file, rows); err != nil {
+			return err
+		}
+		fn(file)
+		count++
+	}
+	explainf("  SQL: %s [%s, %d, %d] found %d rows", sqlStatement, planID, sessionID, limit, count)
+	return rows.Err()
+}
+
+//Get the ID of the most recent session before the given one that wrote any history records, or false if there
+//isn't one. An incremental run that found nothing to back up doesn't write any.
+func lastSessionWithFiles(db *sql.DB, planID string, before int) (int, bool, error) {
+	sqlStatement := `SELECT session_id FROM history WHERE plan_id = ? AND session_id < ? ORDER BY session_id DESC LIMIT 1`
+	var sessionID int
+	err := db.QueryRow(sqlStatement, planID, before).Scan(&sessionID)
+	if err == sql.ErrNoRows {
+		explainf("  SQL: %s [%s, %d] found no sessions", sqlStatement, planID, before)
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	explainf("  SQL: %s [%s, %d] found session %d", sqlStatement, planID, before, sessionID)
+	return sessionID, true, nil
+}
+
+//Get a single session history record by its ID
+func sessionByID(db *sql.DB, sessionID int) (cbbSessionHistoryRow, error) {
+	var session cbbSessionHistoryRow
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM session_history WHERE id = ?`, sqlstruct.Columns(cbbSessionHistoryRow{}))
+	rows, err := db.Query(sqlStatement, sessionID)
+	if err != nil {
+		return session, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return session, err
+		}
+		return session, fmt.Errorf("no session with ID %d", sessionID)
+	}
+	return session, sqlstruct.Scan(&session, rows)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEachSessionFileLimit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cbbackup.db")
+	var files []testFile
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		files = append(files, testFile{path: `C:\data\` + name + ".txt"})
+	}
+	writeTestDB(t, path, []testSession{{id: 1, planID: "11111111-aaaa", started: time.Date(2026, 1, 1, 22, 0, 0, 0, time.UTC), result: cbbResultSuccess, files: files}})
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for limit, expected := range map[int][]string{0: {"a", "b", "c", "d", "e"}, -1: {"a", "b", "c", "d", "e"}, 2: {"a", "b"}, 10: {"a", "b", "c", "d", "e"}} {
+		var got []string
+		err := eachSessionFileLimit(db, "11111111-aaaa", 1, limit, func(f cbbHistoryRow) {
+			got = append(got, f.LocalPath[len(`C:\data\`):len(f.LocalPath)-len(".txt")])
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(got, ",") != strings.Join(expected, ",") {
+			t.Errorf("limit %d: got %v, expected %v", limit, got, expected)
+		}
+	}
+}
+
+//CloudBerry has written session times in more than one format, which don't sort together as strings, so the newest
+//session is the one with the highest id
+func TestPlanSessionsNewestFirst(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cbbackup.db")
+	writeTestDB(t, path, []testSession{
+		{id: 1, planID: "11111111-aaaa", started: time.Date(2026, 1, 1, 22, 0, 0, 0, time.UTC), result: cbbResultSuccess},
+		{id: 2, planID: "11111111-aaaa", started: time.Date(2026, 1, 2, 22, 0, 0, 0, time.UTC), result: cbbResultSuccess},
+	})
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`UPDATE session_history SET date_start_utc = '2026-01-02T22:00:00Z' WHERE id = 2`); err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := planSessions(db, "11111111-aaaa", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 || sessions[0].ID != 2 || sessions[1].ID != 1 {
+		t.Errorf("expected sessions 2 then 1, got %+v", sessions)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"bosun.org/opentsdb"
+)
+
+//What a plan is storing on its destination. These are estimates, CloudBerry doesn't record what is actually in
+//the bucket.
+type planUsage struct {
+	SizeTotal        float64 //Total size of the latest backup
+	UploadedInWindow float64 //Everything uploaded during the plan's retention window
+	Versions         float64 //Estimated number of file versions being kept
+}
+
+//An upper estimate of the bytes stored for a plan: the latest version of everything, plus everything uploaded during
+//the retention window (which will include some of the latest versions again)
+func (u planUsage) StoredEstimate() float64 {
+	return u.SizeTotal + u.UploadedInWindow
+}
+
+//What is stored on each destination (the storage account and bucket that a plan backs up to, identified by the
+//plan's ConnectionID), added up across every plan that backs up to it.
+type destinationUsage struct {
+	Plans int //Number of plans backing up to the destination
+	planUsage
+}
+
+//Work out how long a plan keeps old versions for. Plans that use the default retention settings, or that don't
+//say, get the default that was passed on the command line.
+func retentionWindow(plan cbbBasePlan, defaultWindow time.Duration) time.Duration {
+	if cbbBool(plan.RetentionUseDefaultSettings) {
+		return defaultWindow
+	}
+	if d, ok := parseXSDuration(plan.RetentionDelay); ok && d > 0 {
+		return d
+	}
+	return defaultWindow
+}
+
+//Estimate what a plan is storing from its latest successful session and the sessions in its retention window.
+//A session that is still running or failed part way through hasn't counted the whole backup set, so its total
+//size is too small. sessions is newest first.
+func estimatePlanUsage(plan cbbBasePlan, sessions []cbbSessionHistoryRow, window time.Duration, now time.Time) planUsage {
+	var u planUsage
+	var latest cbbSessionHistoryRow
+	if success := findSessionOutcomes(sessions).LastSuccess; success != nil {
+		latest = *success
+	}
+	u.SizeTotal = float64(latest.TotalSize)
+
+	//Every file has at least its current version, plus a version for every time it was uploaded during the
+	//retention window, up to the number of versions that the plan keeps
+	u.Versions = float64(latest.TotalCount)
+	for _, s := range sessions {
+		started, err := cbbTimeToTime(s.DateStartUtc)
+		if err != nil || now.Sub(started) > window {
+			continue
+		}
+		u.UploadedInWindow += float64(s.UploadedSize)
+		u.Versions += float64(s.UploadedCount)
+	}
+	if keep := cbbInt(plan.RetentionNumberOfVersions); keep > 0 && u.Versions > float64(latest.TotalCount*keep) {
+		u.Versions = float64(latest.TotalCount * keep)
+	}
+	return u
+}
+
+//Add a plan's usage to its destination's usage
+func (u *destinationUsage) addPlan(p planUsage) {
+	u.Plans++
+	u.SizeTotal += p.SizeTotal
+	u.UploadedInWindow += p.UploadedInWindow
+	u.Versions += p.Versions
+}
+
+//Send the usage of every destination
+func sendDestinationUsage(usage map[string]*destinationUsage) {
+	var destinations []string
+	for d := range usage {
+		destinations = append(destinations, d)
+	}
+	sort.Strings(destinations)
+
+	for _, d := range destinations {
+		u := usage[d]
+		bosunDataPoint("cloudberry.destination.plans", u.Plans, opentsdb.TagSet{"destination": d})
+		bosunDataPoint("cloudberry.destination.size_total", u.SizeTotal, opentsdb.TagSet{"destination": d})
+		bosunDataPoint("cloudberry.destination.size_uploaded_retention", u.UploadedInWindow, opentsdb.TagSet{"destination": d})
+		bosunDataPoint("cloudberry.destination.size_stored_estimate", u.StoredEstimate(), opentsdb.TagSet{"destination": d})
+		bosunDataPoint("cloudberry.destination.versions_estimate", u.Versions, opentsdb.TagSet{"destination": d})
+	}
+}
+
+//Get the destination of a plan, for use as a tag
+func planDestination(plan cbbBasePlan) string {
+	if d := strings.TrimSpace(plan.ConnectionID); d != "" {
+		return d
+	}
+	return "unknown"
+}
+
+var xsDurationPattern = regexp.MustCompile(`^(-)?P(?:(\d+)Y)?(?:(\d+)M)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+//.NET writes TimeSpans in to XML as an xs:duration, e.g. P30D or PT12H30M. Years and months are taken to be
+//365 and 30 days.
+func parseXSDuration(v string) (time.Duration, bool) {
+	m := xsDurationPattern.FindStringSubmatch(strings.TrimSpace(v))
+	if m == nil || v == "P" {
+		return 0, false
+	}
+	units := []time.Duration{365 * 24 * time.Hour, 30 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second}
+	var d time.Duration
+	for i, unit := range units {
+		if m[i+2] == "" {
+			continue
+		}
+		n, err := strconv.ParseFloat(m[i+2], 64)
+		if err != nil {
+			return 0, false
+		}
+		d += time.Duration(n * float64(unit))
+	}
+	if m[1] == "-" {
+		d = -d
+	}
+	return d, true
+}
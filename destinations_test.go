@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEstimatePlanUsage(t *testing.T) {
+	now := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+	session := func(result int, daysAgo int, uploaded float32, total float32, totalCount int) cbbSessionHistoryRow {
+		return cbbSessionHistoryRow{Result: result, DateStartUtc: timeToCbbTime(now.AddDate(0, 0, -daysAgo)), UploadedSize: uploaded, UploadedCount: 10, TotalSize: total, TotalCount: totalCount}
+	}
+	plan := cbbBasePlan{RetentionNumberOfVersions: "3"}
+	window := 7 * 24 * time.Hour
+
+	tests := []struct {
+		name     string
+		sessions []cbbSessionHistoryRow
+		expected planUsage
+	}{
+		{"latest succeeded", []cbbSessionHistoryRow{
+			session(cbbResultSuccess, 1, 100, 5000, 100),
+			session(cbbResultSuccess, 2, 200, 4900, 90),
+			session(cbbResultSuccess, 30, 4000, 4000, 80), //Outside the window
+		}, planUsage{SizeTotal: 5000, UploadedInWindow: 300, Versions: 120}},
+		{"latest is running", []cbbSessionHistoryRow{
+			session(cbbResultRunning, 0, 10, 50, 2),
+			session(cbbResultSuccess, 1, 100, 5000, 100),
+		}, planUsage{SizeTotal: 5000, UploadedInWindow: 110, Versions: 120}},
+		{"latest failed early", []cbbSessionHistoryRow{
+			session(8, 0, 0, 0, 0),
+			session(cbbResultSuccess, 1, 100, 5000, 100),
+		}, planUsage{SizeTotal: 5000, UploadedInWindow: 100, Versions: 120}},
+		{"versions limited", []cbbSessionHistoryRow{
+			session(cbbResultSuccess, 1, 100, 5000, 5),
+			session(cbbResultSuccess, 2, 100, 5000, 5),
+		}, planUsage{SizeTotal: 5000, UploadedInWindow: 200, Versions: 15}},
+		{"never succeeded", []cbbSessionHistoryRow{
+			session(8, 1, 100, 5000, 100),
+		}, planUsage{UploadedInWindow: 100}},
+		{"no sessions", nil, planUsage{}},
+	}
+	for _, test := range tests {
+		if got := estimatePlanUsage(plan, test.sessions, window, now); got != test.expected {
+			t.Errorf("%s: got %+v, expected %+v", test.name, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"bosun.org/opentsdb"
+)
+
+//Plans get changed in the CloudBerry UI without anyone being told, so we keep a copy of every plan from the last
+//run and compare it with what is on disk now. This is what we keep for each plan, keyed on the plan ID.
+type planSnapshot struct {
+	Name       string            //The plan name when we last saw it
+	Fields     map[string]string //Every setting in the plan, keyed on its path in the plan XML, with secrets redacted
+	Changes    int               //The number of settings that have changed since we first saw the plan
+	LastChange time.Time         //When we last saw a setting change, or when we first saw the plan
+	FirstSeen  time.Time         //When we first saw the plan, which is when Changes started counting
+}
+
+//A single setting that has changed between two snapshots of a plan
+type planChange struct {
+	Field string
+	Old   string
+	New   string
+}
+
+//The files that we keep in the state directory
+const (
+	planSnapshotsFile = "plans.json"
+	planChangeLogFile = "plan-changes.log"
+)
+
+//Turn a plan in to a flat list of settings, keyed on where they live in the plan XML (e.g. Schedule>RecurType).
+//Lists are joined with commas, and secrets are replaced with a fingerprint so they never get written to disk.
+func snapshotPlan(p cbbBasePlan) map[string]string {
+	fields := make(map[string]string)
+	v := reflect.ValueOf(p.redacted())
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := xmlFieldPath(field)
+
+		var value string
+		switch f := v.Field(i).Interface().(type) {
+		case string:
+			value = strings.TrimSpace(f)
+		case []string:
+			var items []string
+			for _, item := range f {
+				items = append(items, strings.TrimSpace(item))
+			}
+			value = strings.Join(items, ", ")
+		}
+		fields[name] = value
+	}
+	return fields
+}
+
+//Get the path of a plan setting in the plan XML from the struct tag, e.g. Schedule>RecurType or @type
+func xmlFieldPath(field reflect.StructField) string {
+	tag := field.Tag.Get("xml")
+	if strings.HasSuffix(tag, ",attr") {
+		return "@" + strings.TrimSuffix(tag, ",attr")
+	}
+	if tag == "" {
+		return field.Name
+	}
+	return tag
+}
+
+//Compare two snapshots of a plan, returning the changed settings in alphabetical order
+func diffSnapshots(old, new map[string]string) []planChange {
+	var changes []planChange
+	for field, newValue := range new {
+		if oldValue := old[field]; oldValue != newValue {
+			changes = append(changes, planChange{field, oldValue, newValue})
+		}
+	}
+	for field, oldValue := range old {
+		if _, present := new[field]; !present && oldValue != "" {
+			changes = append(changes, planChange{field, oldValue, ""})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+//The plan snapshots file couldn't be read as JSON, e.g. because it was cut short when the disk filled up
+type corruptSnapshotsError struct {
+	path string
+	err  error
+}
+
+func (e corruptSnapshotsError) Error() string {
+	return fmt.Sprintf("the plan snapshots in %s are corrupt, starting again without them: %v", e.path, e.err)
+}
+
+//Load the plan snapshots from the last run. It's not an error for there not to be any, that just means that
+//this is the first run. A file that isn't valid JSON is a corruptSnapshotsError.
+func loadPlanSnapshots(stateDir string) (map[string]*planSnapshot, error) {
+	snapshots := make(map[string]*planSnapshot)
+	path := filepath.Join(stateDir, planSnapshotsFile)
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return snapshots, nil
+	} else if err != nil {
+		return snapshots, err
+	}
+	if err := json.Unmarshal(b, &snapshots); err != nil {
+		return make(map[string]*planSnapshot), corruptSnapshotsError{path, err}
+	}
+	return snapshots, nil
+}
+
+//Save the plan snapshots for the next run. They're written to a temporary file first so that a collector that gets
+//killed half way through doesn't leave a broken file behind.
+func savePlanSnapshots(stateDir string, snapshots map[string]*planSnapshot) error {
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(snapshots, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(stateDir, planSnapshotsFile)
+	if err := ioutil.WriteFile(path+".tmp", b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+//Add the changes to a plan to the human readable change log in the state directory
+func logPlanChanges(stateDir string, now time.Time, plan cbbBasePlan, changes []planChange) error {
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(stateDir, planChangeLogFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for _, c := range changes {
+		_, err := fmt.Fprintf(f, "%s\tplan=%q\tid=%s\tfield=%s\told=%q\tnew=%q\r\n", now.UTC().Format(time.RFC3339), plan.Name, plan.ID, c.Field, c.Old, c.New)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Compare every plan with its snapshot from the last run, logging anything that has changed and saving the new
+//snapshots for next time. Plans that have been deleted are dropped from the snapshots. If persist is false, the
+//changes are worked out but nothing is written to the state directory.
+func trackPlanDrift(plans []cbbBasePlan, stateDir string, now time.Time, persist bool) (map[string]*planSnapshot, error) {
+	previous, err := loadPlanSnapshots(stateDir)
+	if corrupt, ok := err.(corruptSnapshotsError); ok {
+		//A corrupt file would otherwise fail every run and never get rewritten, so start again from nothing. The bad
+		//file is kept to one side in case anyone wants to see what happened to it.
+		reportError(corrupt)
+		if persist {
+			if err := os.Rename(corrupt.path, corrupt.path+".corrupt"); err != nil {
+				reportError(err)
+			}
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	current := make(map[string]*planSnapshot)
+	dirty := len(previous) != len(plans)
+	for _, plan := range plans {
+		fields := snapshotPlan(plan)
+		snapshot, seen := previous[plan.ID]
+		if seen && snapshot.FirstSeen.IsZero() {
+			//Snapshots from before we kept this. The last change is the earliest time we still know about.
+			snapshot.FirstSeen = snapshot.LastChange
+			dirty = true
+		}
+		if !seen {
+			current[plan.ID] = &planSnapshot{Name: plan.Name, Fields: fields, LastChange: now, FirstSeen: now}
+			dirty = true
+			continue
+		}
+
+		if changes := diffSnapshots(snapshot.Fields, fields); len(changes) > 0 {
+			for _, c := range changes {
+				explainf("Plan %q setting %s has changed from %q to %q", plan.Name, c.Field, c.Old, c.New)
+			}
+			if persist {
+				if err := logPlanChanges(stateDir, now, plan, changes); err != nil {
+					return nil, err
+				}
+			}
+			snapshot.Name = plan.Name
+			snapshot.Fields = fields
+			snapshot.Changes += len(changes)
+			snapshot.LastChange = now
+			dirty = true
+		}
+		current[plan.ID] = snapshot
+	}
+
+	//Only write the snapshots out when something has changed, no point rewriting the same file every run
+	if dirty && persist {
+		if err := savePlanSnapshots(stateDir, current); err != nil {
+			return current, err
+		}
+	}
+	return current, nil
+}
+
+//Send the configuration change metrics for a plan
+func sendPlanDrift(plan cbbBasePlan, snapshot *planSnapshot) {
+	bosunCounterDataPoint("cloudberry.plan.config_changes_total", snapshot.Changes, opentsdb.TagSet{"job": plan.Name}, snapshot.FirstSeen)
+	bosunDataPoint("cloudberry.plan.last_config_change", snapshot.LastChange.Unix(), opentsdb.TagSet{"job": plan.Name})
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlanDrift(t *testing.T) {
+	stateDir := t.TempDir()
+	now := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	plan := cbbBasePlan{ID: "11111111-aaaa", Name: "Nightly", RecurTypeSchedule: "Daily"}
+
+	snapshots, err := trackPlanDrift([]cbbBasePlan{plan}, stateDir, now, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := snapshots[plan.ID]; s.Changes != 0 || !s.FirstSeen.Equal(now) || !s.LastChange.Equal(now) {
+		t.Errorf("first run: %+v", s)
+	}
+
+	plan.RecurTypeSchedule = "Weekly"
+	later := now.Add(24 * time.Hour)
+	snapshots, err = trackPlanDrift([]cbbBasePlan{plan}, stateDir, later, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := snapshots[plan.ID]; s.Changes != 1 || !s.FirstSeen.Equal(now) || !s.LastChange.Equal(later) {
+		t.Errorf("after a change: %+v", s)
+	}
+	log, err := ioutil.ReadFile(filepath.Join(stateDir, planChangeLogFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(log), `old="Daily"	new="Weekly"`) {
+		t.Errorf("the change wasn't logged: %s", log)
+	}
+}
+
+//A snapshots file that has been cut short mustn't stop drift tracking for good
+func TestCorruptPlanSnapshots(t *testing.T) {
+	var errs bytes.Buffer
+	previousErrorOut := errorOut
+	errorOut = &errs
+	t.Cleanup(func() { errorOut = previousErrorOut })
+
+	stateDir := t.TempDir()
+	path := filepath.Join(stateDir, planSnapshotsFile)
+	corrupt := []byte(`{"11111111-aaaa": {"Name": "Nigh`)
+	if err := ioutil.WriteFile(path, corrupt, 0600); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	plans := []cbbBasePlan{{ID: "11111111-aaaa", Name: "Nightly"}}
+
+	//Only explaining, so the state directory is left alone
+	if _, err := trackPlanDrift(plans, stateDir, now, false); err != nil {
+		t.Fatalf("a corrupt file shouldn't be an error: %v", err)
+	}
+	if b, _ := ioutil.ReadFile(path); !bytes.Equal(b, corrupt) {
+		t.Error("the snapshots were changed when not persisting")
+	}
+
+	errs.Reset()
+	snapshots, err := trackPlanDrift(plans, stateDir, now, true)
+	if err != nil {
+		t.Fatalf("a corrupt file shouldn't be an error: %v", err)
+	}
+	if !strings.Contains(errs.String(), "corrupt") {
+		t.Errorf("the corrupt file wasn't reported: %q", errs.String())
+	}
+	if snapshots["11111111-aaaa"] == nil {
+		t.Error("the plan should have a new snapshot")
+	}
+	if b, err := ioutil.ReadFile(path + ".corrupt"); err != nil || !bytes.Equal(b, corrupt) {
+		t.Errorf("the corrupt file wasn't kept: %v", err)
+	}
+
+	errs.Reset()
+	if _, err := loadPlanSnapshots(stateDir); err != nil {
+		t.Errorf("the new snapshots can't be loaded: %v", err)
+	}
+	if _, err := trackPlanDrift(plans, stateDir, now, true); err != nil || errs.Len() > 0 {
+		t.Errorf("the next run should be clean, got %v and %q", err, errs.String())
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Error(err)
+	}
+}
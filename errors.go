@@ -0,0 +1,120 @@
+package main
+
+import (
+	"strings"
+
+	"bosun.org/opentsdb"
+)
+
+//The error classes that we group failures in to. CloudBerry writes the raw .NET exception message in to the
+//database, which is far too varied to use as a tag, so each message is normalised to one of these.
+var cbbErrorClasses = []string{
+	"access_denied",
+	"file_locked",
+	"path_too_long",
+	"network",
+	"quota",
+	"other",
+}
+
+//Phrases that identify each error class, checked in order against the lower cased error message. The first
+//class with a matching phrase or HTTP status code wins, anything that doesn't match is "other".
+var cbbErrorClassPhrases = []struct {
+	Class       string
+	Phrases     []string
+	StatusCodes []string //Only matched as a number on their own, see hasStatusCode
+}{
+	{"access_denied", []string{"access is denied", "access to the path", "unauthorized", "permission", "forbidden"}, []string{"403"}},
+	{"file_locked", []string{"being used by another process", "locked a portion of the file", "sharing violation", "file is locked", "file is in use"}, nil},
+	{"path_too_long", []string{"path is too long", "too long", "path too long", "260 characters", "maxpath"}, nil},
+	{"quota", []string{"quota", "not enough space", "disk is full", "insufficient storage", "storage limit"}, nil},
+	{"network", []string{"network", "connection", "timed out", "timeout", "remote name could not be resolved", "no such host", "remote host", "socket", "unable to connect", "service unavailable"}, []string{"503"}},
+}
+
+//Normalise a raw CloudBerry error message in to one of cbbErrorClasses
+func classifyError(message string) string {
+	message = strings.ToLower(message)
+	for _, c := range cbbErrorClassPhrases {
+		for _, phrase := range c.Phrases {
+			if strings.Contains(message, phrase) {
+				return c.Class
+			}
+		}
+		for _, code := range c.StatusCodes {
+			if hasStatusCode(message, code) {
+				return c.Class
+			}
+		}
+	}
+	return "other"
+}
+
+//Whether a message has an HTTP status code in it as a number on its own, such as "(403) Forbidden" or "HTTP 503:",
+//rather than as part of something else such as "1403 files", "1,403 bytes" or a file name
+func hasStatusCode(message string, code string) bool {
+	for start := 0; start < len(message); {
+		i := strings.Index(message[start:], code)
+		if i < 0 {
+			return false
+		}
+		i += start
+		end := i + len(code)
+		if (i == 0 || strings.ContainsRune(" \t\r\n(:", rune(message[i-1]))) &&
+			(end == len(message) || strings.ContainsRune(" \t\r\n):,.", rune(message[end]))) {
+			return true
+		}
+		start = i + 1
+	}
+	return false
+}
+
+//Failures in a session, grouped by error class. Every file in the session that has a message against it counts
+//as a failure, as does the session level error message if there is one.
+type sessionErrors struct {
+	Classes     map[string]int //Number of failures in each error class
+	LastMessage string         //The most recent raw error message
+}
+
+func newSessionErrors() *sessionErrors {
+	e := &sessionErrors{Classes: make(map[string]int)}
+	for _, class := range cbbErrorClasses {
+		e.Classes[class] = 0
+	}
+	return e
+}
+
+//Count a single file from the session if it failed. Files are handed over in the order that they finished, so
+//the last message we see is the most recent one.
+func (e *sessionErrors) addFile(file cbbHistoryRow) {
+	message := strings.TrimSpace(file.Message)
+	if message == "" {
+		return
+	}
+	e.Classes[classifyError(message)]++
+	e.LastMessage = message
+}
+
+//Add the session level error message once all of the files have been added. This is the message that
+//CloudBerry shows in its own UI, so it takes priority over the per-file messages.
+func (e *sessionErrors) finish(session cbbSessionHistoryRow) {
+	message := strings.TrimSpace(session.ErrorMessage)
+	if message == "" {
+		return
+	}
+	e.Classes[classifyError(message)]++
+	e.LastMessage = message
+}
+
+//Send the failures for the latest session of a plan to Bosun. We always send every class, even when it is zero,
+//so that alerts can see a class go back to zero once the problem has been fixed.
+func sendSessionErrors(e *sessionErrors, planName string) {
+	for _, class := range cbbErrorClasses {
+		bosunDataPoint("cloudberry.job.errors", e.Classes[class], opentsdb.TagSet{"job": planName, "class": class})
+	}
+
+	//The raw error message is no good as a tag, but it is useful to whoever is looking at the alert, so send
+	//it as metadata against the job instead.
+	if e.LastMessage != "" {
+		bosunMetadata("cloudberry.job.errors", "last_error", e.LastMessage, opentsdb.TagSet{"job": planName})
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		message string
+		class   string
+	}{
+		{`Access to the path 'C:\data\secret.txt' is denied.`, "access_denied"},
+		{"Access is denied", "access_denied"},
+		{"The remote server returned an error: (403) Forbidden.", "access_denied"},
+		{"HTTP 403", "access_denied"},
+		{"The process cannot access the file because it is being used by another process.", "file_locked"},
+		{"The specified path, file name, or both are too long.", "path_too_long"},
+		{"There is not enough space on the disk.", "quota"},
+		{"The remote server returned an error: (503) Server Unavailable.", "network"},
+		{"Status: 503", "network"},
+		{"A connection attempt failed because the connected party did not properly respond", "network"},
+		{"Request to the destination failed", "other"},
+		//Numbers that only look like status codes
+		{"Skipped 1,403 bytes of padding", "other"},
+		{"Could not read block 14035", "other"},
+		{`Failed to read C:\data\403b-plan.docx`, "other"},
+		{"Checksum mismatch in chunk 5031", "other"},
+		{"The request is denied by the hardware", "other"},
+	}
+	for _, test := range tests {
+		if class := classifyError(test.message); class != test.class {
+			t.Errorf("classifyError(%q) = %s, expected %s", test.message, class, test.class)
+		}
+	}
+}
+
+func TestHasStatusCode(t *testing.T) {
+	for message, expected := range map[string]bool{
+		"403":             true,
+		"(403) forbidden": true,
+		"error: 403.":     true,
+		"http 403, again": true,
+		"1403":            false,
+		"4031":            false,
+		"1,403":           false,
+		"403b":            false,
+		"v403":            false,
+		"":                false,
+	} {
+		if got := hasStatusCode(message, "403"); got != expected {
+			t.Errorf("hasStatusCode(%q) = %v, expected %v", message, got, expected)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+//When the explain command is running, this is where the explanation of each step goes, and data points are written
+//here in a readable form instead of being sent to scollector. It is nil the rest of the time.
+var explainOut io.Writer
+
+//Explain a step of the collection, if anyone is asking
+func explainf(format string, a ...interface{}) {
+	if explainOut != nil {
+		fmt.Fprintf(explainOut, format+"\n", a...)
+	}
+}
+
+//Where errors are reported. This is stderr, so that scollector can log them.
+var errorOut io.Writer = os.Stderr
+
+//Report a step that went wrong. The error always goes to stderr so that scollector can log it, and is also part of
+//the explanation if we're explaining.
+func reportError(err error) {
+	fmt.Fprintln(errorOut, err)
+	explainf("  ERROR: %v", err)
+}
+
+//Go through a normal collection, explaining each step and showing every data point that would be sent, without
+//sending anything or saving anything to the state directory. This takes the same flags as a normal collection.
+func explainCommand(args []string) error {
+	flag.CommandLine.Parse(args)
+	explainOut = os.Stdout
+	return collect()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"time"
+
+	"bosun.org/opentsdb"
+)
+
+//CloudBerry doesn't record whether a session was a full backup or an incremental one, so we have to work it out.
+//A session that uploaded (nearly) as much as a full backup would is counted as a full. The oldest session that we
+//know about is also counted as a full if it's the very first run of the plan, as the first run has to upload
+//everything.
+func isFullSession(session cbbSessionHistoryRow, fullRatio float64, fullSize float64) bool {
+	if session.Result != cbbResultSuccess || fullSize <= 0 {
+		return false
+	}
+	return float64(session.UploadedSize) >= fullRatio*fullSize
+}
+
+//How much a full backup uploads, for each of a plan's sessions. That's the whole backup set, unless the plan
+//compresses its data, in which case a full uploads less than the backup set by however well the data compresses.
+//For those plans we use the largest upload in the sessions that we have, which is a full unless every one of them
+//is an incremental.
+func fullBackupSizes(sessions []cbbSessionHistoryRow, compressed bool) []float64 {
+	var largest float64
+	for _, s := range sessions {
+		if compressed && s.Result == cbbResultSuccess && float64(s.UploadedSize) > largest {
+			largest = float64(s.UploadedSize)
+		}
+	}
+	sizes := make([]float64, len(sessions))
+	for i, s := range sessions {
+		sizes[i] = float64(s.TotalSize)
+		if compressed {
+			sizes[i] = largest
+		}
+	}
+	return sizes
+}
+
+//Where a plan is in its chain of incremental backups
+type fullBackupChain struct {
+	LastFull       *cbbSessionHistoryRow //The most recent full backup, or nil if we couldn't find one
+	LatestIsFull   bool                  //Whether the most recent session was a full backup
+	ChainLength    int                   //Number of sessions since the last full backup
+	SizeSinceFull  float64               //Total amount uploaded by the sessions since the last full backup
+	fullSizeOfLast float64               //The size of the last full backup
+}
+
+//Work out the incremental chain from a plan's sessions (newest first). complete says whether the sessions go all
+//the way back to the first run of the plan, and compressed whether the plan compresses its data.
+func findFullBackupChain(sessions []cbbSessionHistoryRow, complete bool, fullRatio float64, compressed bool) fullBackupChain {
+	var c fullBackupChain
+	fullSizes := fullBackupSizes(sessions, compressed)
+	for i := range sessions {
+		first := complete && i == len(sessions)-1
+		if isFullSession(sessions[i], fullRatio, fullSizes[i]) || (first && sessions[i].Result == cbbResultSuccess) {
+			c.LastFull = &sessions[i]
+			c.LatestIsFull = i == 0
+			c.fullSizeOfLast = float64(sessions[i].UploadedSize)
+			return c
+		}
+		//Only successful runs add a link to the chain, a failed run doesn't need restoring
+		if sessions[i].Result == cbbResultSuccess {
+			c.ChainLength++
+			c.SizeSinceFull += float64(sessions[i].UploadedSize)
+		}
+	}
+	return c
+}
+
+//Send the full backup metrics for a plan
+func sendFullBackupMetrics(plan cbbBasePlan, c fullBackupChain) {
+	bosunDataPoint("cloudberry.job.full_backup", boolToInt(c.LatestIsFull), opentsdb.TagSet{"job": plan.Name})
+
+	//We can't say anything about the chain if the last full is further back than the history that we looked at
+	if c.LastFull == nil {
+		return
+	}
+	bosunDataPoint("cloudberry.job.incremental_chain_length", c.ChainLength, opentsdb.TagSet{"job": plan.Name})
+
+	lastFullStarted, err := cbbTimeToTime(c.LastFull.DateStartUtc)
+	if err != nil {
+		return
+	}
+	bosunDataPoint("cloudberry.job.time_since_last_full", time.Since(lastFullStarted).Seconds(), opentsdb.TagSet{"job": plan.Name})
+
+	if next, ok := plan.forceFullSchedule().next(lastFullStarted); ok {
+		bosunDataPoint("cloudberry.job.time_until_next_full", time.Until(next).Seconds(), opentsdb.TagSet{"job": plan.Name})
+	}
+
+	//CloudBerry can also force a full backup once the incrementals add up to a percentage of the full backup, so
+	//show how close the plan is to that
+	if cbbBool(plan.ForceFullApplyDiffSizeCondition) && c.fullSizeOfLast > 0 {
+		bosunDataPoint("cloudberry.job.diff_size_pct", 100*c.SizeSinceFull/c.fullSizeOfLast, opentsdb.TagSet{"job": plan.Name})
+		bosunDataPoint("cloudberry.job.diff_size_limit_pct", cbbInt(plan.ForceFullDiffSizeCondition), opentsdb.TagSet{"job": plan.Name})
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import "testing"
+
+func TestFindFullBackupChain(t *testing.T) {
+	session := func(result int, uploaded, total float32) cbbSessionHistoryRow {
+		return cbbSessionHistoryRow{Result: result, UploadedSize: uploaded, TotalSize: total, DateStartUtc: "20260102150405"}
+	}
+	const gb = 1 << 30
+
+	tests := []struct {
+		name       string
+		sessions   []cbbSessionHistoryRow //Newest first
+		complete   bool
+		compressed bool
+		fullAt     int //Index of the last full, or -1 if there isn't one
+		chain      int
+	}{
+		{"uncompressed full", []cbbSessionHistoryRow{
+			session(cbbResultSuccess, 0.1*gb, 100*gb),
+			session(8, 0.1*gb, 100*gb),
+			session(cbbResultSuccess, 0.2*gb, 100*gb),
+			session(cbbResultSuccess, 95*gb, 100*gb),
+			session(cbbResultSuccess, 0.1*gb, 100*gb),
+		}, false, false, 3, 2},
+		{"compressed full is missed without compression", []cbbSessionHistoryRow{
+			session(cbbResultSuccess, 0.1*gb, 100*gb),
+			session(cbbResultSuccess, 40*gb, 100*gb),
+			session(cbbResultSuccess, 0.1*gb, 100*gb),
+		}, false, false, -1, 3},
+		{"compressed full", []cbbSessionHistoryRow{
+			session(cbbResultSuccess, 0.1*gb, 100*gb),
+			session(cbbResultSuccess, 0.2*gb, 100*gb),
+			session(cbbResultSuccess, 40*gb, 100*gb),
+			session(cbbResultSuccess, 0.1*gb, 100*gb),
+			session(cbbResultSuccess, 41*gb, 100*gb),
+		}, false, true, 2, 2},
+		{"compressed full, latest", []cbbSessionHistoryRow{
+			session(cbbResultSuccess, 39*gb, 100*gb),
+			session(cbbResultSuccess, 0.1*gb, 100*gb),
+			session(cbbResultSuccess, 40*gb, 100*gb),
+		}, false, true, 0, 0},
+		{"failed compressed run doesn't count", []cbbSessionHistoryRow{
+			session(cbbResultSuccess, 0.1*gb, 100*gb),
+			session(8, 90*gb, 100*gb),
+			session(cbbResultSuccess, 40*gb, 100*gb),
+		}, false, true, 2, 1},
+		{"first run of the plan", []cbbSessionHistoryRow{
+			session(cbbResultSuccess, 0.1*gb, 100*gb),
+			session(cbbResultSuccess, 0.1*gb, 100*gb),
+		}, true, false, 1, 1},
+		{"nothing uploaded", []cbbSessionHistoryRow{
+			session(cbbResultSuccess, 0, 100*gb),
+			session(cbbResultSuccess, 0, 100*gb),
+		}, false, true, -1, 2},
+	}
+	for _, test := range tests {
+		c := findFullBackupChain(test.sessions, test.complete, 0.9, test.compressed)
+		fullAt := -1
+		for i := range test.sessions {
+			if c.LastFull == &test.sessions[i] {
+				fullAt = i
+			}
+		}
+		if fullAt != test.fullAt || c.ChainLength != test.chain || c.LatestIsFull != (test.fullAt == 0) {
+			t.Errorf("%s: full at %d with a chain of %d (latest is full %v), expected full at %d with a chain of %d", test.name, fullAt, c.ChainLength, c.LatestIsFull, test.fullAt, test.chain)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"bosun.org/metadata"
+	"bosun.org/opentsdb"
+)
+
+//Graphite plaintext, either as dotted paths or as tagged series. Dotted paths are the metric name followed by the
+//tag values, in the order given by -graphite-tags and then the rest of the tags in alphabetical order, e.g.
+//cloudberry.job.size_uploaded.myhost.My_Plan. Graphite has nowhere to put metadata, so it is dropped. Like line
+//protocol, values that came from a session are stamped with when the session finished.
+type graphiteOutput struct {
+	w        io.Writer
+	tagOrder []string
+	tagged   bool
+}
+
+func (o *graphiteOutput) writeDataPoint(dp opentsdb.DataPoint, at pointTimes) error {
+	value, ok := graphiteValue(dp.Value)
+	if !ok {
+		return nil
+	}
+	ts := dp.Timestamp
+	if !at.Session.IsZero() {
+		ts = at.Session.Unix()
+	}
+
+	var path string
+	if o.tagged {
+		path = graphiteTaggedPath(dp.Metric, dp.Tags)
+	} else {
+		path = graphitePath(dp.Metric, dp.Tags, o.tagOrder)
+	}
+	_, err := fmt.Fprintf(o.w, "%s %s %d\n", path, value, ts)
+	return err
+}
+
+func (o *graphiteOutput) writeMetadata(m metadata.Metasend) error {
+	return nil
+}
+
+func (o *graphiteOutput) flush() error {
+	return nil
+}
+
+//StatsD gauges, using the same dotted paths as Graphite. StatsD stamps everything with when it flushes, so there
+//are no timestamps.
+type statsdOutput struct {
+	w        io.Writer
+	tagOrder []string
+}
+
+func (o *statsdOutput) writeDataPoint(dp opentsdb.DataPoint, at pointTimes) error {
+	value, ok := graphiteValue(dp.Value)
+	if !ok {
+		return nil
+	}
+	_, err := fmt.Fprintf(o.w, "%s:%s|g\n", graphitePath(dp.Metric, dp.Tags, o.tagOrder), value)
+	return err
+}
+
+func (o *statsdOutput) writeMetadata(m metadata.Metasend) error {
+	return nil
+}
+
+func (o *statsdOutput) flush() error {
+	return nil
+}
+
+//Build a dotted path from a metric and its tag values. The tag values are cleaned the same way as they are for
+//OpenTSDB (a space or a semicolon would break the line), and then dots are replaced, as they would add levels to
+//the path.
+func graphitePath(metric string, tags opentsdb.TagSet, tagOrder []string) string {
+	path := metric
+	for _, k := range orderTags(tags, tagOrder) {
+		path += "." + strings.Replace(escapeTagContent(tags[k]), ".", "_", -1)
+	}
+	return path
+}
+
+//Build a tagged series name, e.g. cloudberry.job.size_uploaded;host=myhost;job=My_Plan
+func graphiteTaggedPath(metric string, tags opentsdb.TagSet) string {
+	path := metric
+	for _, k := range orderTags(tags, nil) {
+		path += ";" + k + "=" + escapeTagContent(tags[k])
+	}
+	return path
+}
+
+//Put the tags that have an order first, in that order, followed by the rest in alphabetical order
+func orderTags(tags opentsdb.TagSet, order []string) []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, k := range order {
+		if _, ok := tags[k]; ok && !seen[k] {
+			keys = append(keys, k)
+			seen[k] = true
+		}
+	}
+	var rest []string
+	for k := range tags {
+		if !seen[k] {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+	return append(keys, rest...)
+}
+
+//Graphite only takes numbers, so booleans are sent as 1 and 0, and anything else can't be sent
+func graphiteValue(value interface{}) (string, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), true
+	case reflect.Float32:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 32), true
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64), true
+	case reflect.Bool:
+		return strconv.Itoa(boolToInt(v.Bool())), true
+	}
+	return "", false
+}
+
+//Connect to wherever -send points, e.g. tcp://graphite:2003 or udp://statsd:8125. Each line is written separately,
+//so over UDP each data point is its own packet.
+func dialOutput(address string) (net.Conn, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "tcp" && u.Scheme != "udp" {
+		return nil, fmt.Errorf("can't send to %q, the address needs to start with tcp:// or udp://", address)
+	}
+	return net.DialTimeout(u.Scheme, u.Host, 10*time.Second)
+}
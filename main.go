@@ -4,9 +4,11 @@ import (
 	"database/sql"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -16,7 +18,6 @@ import (
 	"bosun.org/metadata"
 	"bosun.org/opentsdb"
 	"bosun.org/util"
-	"github.com/kisielk/sqlstruct"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -24,47 +25,82 @@ import (
 var CBProgramData = "C:\\ProgramData\\CloudBerry Backup Enterprise Edition"
 
 var (
-	sqlLiteDB           string        //This will be the path to the CloudBerry SQL Lite database
-	cbbPlansBackups     []cbbBasePlan //A collection of CloudBerry backup plans
-	cbbPlansConsistency []cbbBasePlan //A collection of CloudBerry consistency check plans
+	sqlLiteDB           string                    //This will be the path to the CloudBerry SQL Lite database
+	cbbPlansBackups     []cbbBasePlan             //A collection of CloudBerry backup plans
+	cbbPlansConsistency []cbbBasePlan             //A collection of CloudBerry consistency check plans
+	cbbPlanFiles        = make(map[string]string) //The .cbb file that each plan was read from, keyed on plan ID
 )
 
-//Metadata for the metrics that we are going to send to Bosun. Our metadata and counters are fairly simple, so we can just define them here and send them once,
-//without having to send them again later.
-var metaData = map[string]standardMetrics{
-	"cloudberry.job.files":                 {metadata.Gauge, metadata.Count, "The operation taken on the file during the last job run. -1 = purged, 1 = backed up. Filenames are sanitised as such: Letters, numbers, periods and hyphens are unchanged. Slahes are converted to a hyphen, spaces are converted to underscores. All other characters are stripped."},
-	"cloudberry.job.status":                {metadata.Gauge, metadata.Count, "The last reported status of the last job run."},
-	"cloudberry.job.files_uploaded":        {metadata.Gauge, metadata.Count, "The number of files uploaded in the last job run."},
-	"cloudberry.job.job_duration":          {metadata.Gauge, metadata.Second, "The last reported duration of the job."},
-	"cloudberry.job.time_since_last_start": {metadata.Gauge, metadata.Second, "Time since the job last started."},
-	"cloudberry.job.size_uploaded":         {metadata.Gauge, metadata.Bytes, "The size of the data that was uploaded as reported by the last run of the job."},
-	"cloudberry.job.size_total":            {metadata.Gauge, metadata.Bytes, "The total size of the last backup job (i.e. not just what was uploaded)."},
-	"cloudberry.job.count":                 {metadata.Gauge, metadata.Count, "Number of backup jobs registered."},
+func main() {
+	//scollector runs us without any arguments (or with just flags), which means collect metrics. Anything else is a
+	//subcommand for someone troubleshooting from a shell.
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		if err := runCommand(os.Args[1], os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	flag.Parse()
+	if err := collect(); err != nil {
+		panic(err.Error())
+	}
 }
 
-func main() {
-	//Loop through all of the files that are in the CloudBerry ProgramData folder. We're ultimately looking for
-	//*.cbb and cbbackup.db. *.cbb are the plan XML files, and cbbackup.db is the SQL Lite database
+//Loop through all of the files that are in the CloudBerry ProgramData folder. We're ultimately looking for
+//*.cbb and cbbackup.db. *.cbb are the plan XML files, and cbbackup.db is the SQL Lite database
+func discoverCloudBerry() error {
+	explainf("Looking for plans and the database in %s", CBProgramData)
 	err := filepath.Walk(filepath.Join(CBProgramData), processCBBFile)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
+		reportError(err)
 	}
 
 	//If we don't have any backup plans, no point in continuing
 	if len(cbbPlansBackups) == 0 {
-		panic("Did not locate any backup plans")
+		return errors.New("Did not locate any backup plans")
 	}
 
 	//If we didn't locate an SQLLite database, no point in continuing
 	if sqlLiteDB == "" {
-		panic("Did not locate Cloudberry database (cbbackup.db)")
+		return errors.New("Did not locate Cloudberry database (cbbackup.db)")
 	}
+	return nil
+}
+
+//Attempt to open the SQL Lite database. This should be safe, as SQL Lite doesn't lock anything unless you perform
+//a write command, which we have no intention of doing.
+func openCloudBerryDB() (*sql.DB, error) {
+	return sql.Open("sqlite3", sqlLiteDB)
+}
 
-	//Attempt to open the SQL Lite database. This should be safe, as SQL Lite doesn't lock anything unless you perform
-	//a write command, which we have no intention of doing.
-	db, err := sql.Open("sqlite3", sqlLiteDB)
+//Collect the metrics for every backup plan and send them to stdout for scollector. If we can't find the plans or the
+//database then there's nothing to collect, and we return an error.
+func collect() error {
+	out, closeOutput, err := openOutput()
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	output = out
+	defer closeOutput()
+
+	discoverErr := discoverCloudBerry()
+
+	//Show what we made of the plan files, if asked. This goes to stderr so that scollector doesn't try to treat it as a metric.
+	if *debugFlag {
+		if err := writeDebugPlans(os.Stderr, append(append([]cbbBasePlan{}, cbbPlansBackups...), cbbPlansConsistency...)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+
+	if discoverErr != nil {
+		return discoverErr
+	}
+
+	db, err := openCloudBerryDB()
+	if err != nil {
+		return err
 	}
 	defer db.Close()
 
@@ -72,98 +108,219 @@ func main() {
 	sendMetadata()
 
 	//Log the number of jobs that we saw configured in CloudBerry (based on the number of XML, sorry .cbb, files we found)
-	bosunDataPoint("cloudberry.jobs.count", len(cbbPlansBackups), opentsdb.TagSet{})
+	bosunDataPoint("cloudberry.job.count", len(cbbPlansBackups), opentsdb.TagSet{})
+
+	//The list of file extensions that count towards the ransomware indicators
+	suspiciousExtensions := parseExtensionList(*suspiciousExtensionsFlag)
+
+	//The plans that have to notify someone when they fail. If the expression is broken, skip the check rather than reporting every plan.
+	productionPlans, err := regexp.Compile(*productionPlansFlag)
+	if err != nil {
+		reportError(err)
+	}
+
+	//Compare the plans with how they looked last time we ran, so that we can tell when someone has changed them. When
+	//we're only explaining, the state directory is left alone.
+	planSnapshots, err := trackPlanDrift(cbbPlansBackups, *stateDirFlag, time.Now(), explainOut == nil)
+	if err != nil {
+		reportError(err)
+	}
+
+	//What each destination is storing and costing, added up from every plan that backs up to it
+	destinations := make(map[string]*destinationUsage)
+	destinationCosts := make(map[string]float64)
+	prices, err := loadPriceTable(*pricesFlag)
+	costing := err == nil
+	if err != nil {
+		reportError(fmt.Errorf("not estimating costs: %v", err))
+	}
 
 	//Process the backup plans. This is going to load the backup plan XML to get its metadata (name, etc). Then it's going to query the SQL Lite database
 	//to get the history of the backup plan (files uploaded, time taken, etc). Once we have an individual historical run, we can query for more details
 	//about that run, such as the actions taken during the run (backed up file, purged file, etc)
 	for _, x := range cbbPlansBackups {
-		var cbbSessionHistory cbbSessionHistoryRow //Holds the Session History (which is a record of each run of a backup job)
-		var sqlStatement string
+		explainf("\nPlan %q (ID %s) from %s", x.Name, x.ID, cbbPlanFiles[x.ID])
+		sendPlanActions(x)
+		sendEncryptionSettings(x)
+		sendStorageSettings(x)
+		sendNotificationSettings(x, productionPlans)
+		if snapshot, ok := planSnapshots[x.ID]; ok {
+			sendPlanDrift(x, snapshot)
+		}
 
-		//Get the most recent session history record for this backup plan
-		sqlStatement = fmt.Sprintf(`SELECT %s FROM session_history WHERE plan_id = '%s' ORDER BY date_start_utc DESC LIMIT 0,1`, sqlstruct.Columns(cbbSessionHistory), x.ID)
+		//Get the most recent session history records for this backup plan. Session history is a record of each run of a backup job,
+		//we mostly care about the latest one, but the ones before it give us something to compare against.
+		sessions, err := planSessions(db, x.ID, *sessionHistoryFlag)
+		if err != nil {
+			reportError(err) //If we couldn't load the rows into our object, throw this to stderr so that scollector can log the error
+		}
+		destination := planDestination(x)
+		if destinations[destination] == nil {
+			destinations[destination] = &destinationUsage{}
+		}
+		usage := estimatePlanUsage(x, sessions, retentionWindow(x, *defaultRetentionFlag), time.Now())
+		destinations[destination].addPlan(usage)
+		if costing {
+			destinationCosts[destination] += sendPlanCost(prices, x, usage)
+		}
+
+		if len(sessions) == 0 {
+			explainf("  No sessions found for this plan, so there are no job metrics for it")
+		} else {
+			cbbSessionHistory := sessions[0]
+			var previousSession *cbbSessionHistoryRow
+			if len(sessions) > 1 {
+				previousSession = &sessions[1]
+			}
 
-		//Using the sqlstruct package here because the field names in the database are not valid GoLang field names. There are struct tags to map the GoLang name
-		//to the SQL field name
-		rows, err := db.Query(sqlStatement, sqlstruct.Columns(cbbSessionHistoryRow{}))
-		for rows.Next() {
-			err = sqlstruct.Scan(&cbbSessionHistory, rows)
+			timeTaken := time.Duration(cbbSessionHistory.Duration) * time.Second //Create a GoLang representation of the amount of time the backup took
+			timeStarted, err := cbbTimeToTime(cbbSessionHistory.DateStartUtc)    //Get a GoLang representation of the time that the backup started at
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err) //If we couldn't load the row into our object, throw this to stderr so that scollector can log the error
-			} else {
-				timeTaken := time.Duration(cbbSessionHistory.Duration) * time.Second //Create a GoLang representation of the amount of time the backup took
-				timeStarted, _ := cbbTimeToTime(cbbSessionHistory.DateStartUtc)      //Get a GoLang representation of the time that the backup started at
-				//timeFinished := timeStarted.Add(timeTaken)
-
-				//Some stats that can be gleamed from the most recent history record. You check the the metadata at the top of this file if you want more details
-				//about what is being sent here (look up the record with the same metric name)
-				bosunDataPoint("cloudberry.job.status", cbbSessionHistory.Result, opentsdb.TagSet{"job": x.Name})
-				bosunDataPoint("cloudberry.job.files_uploaded", cbbSessionHistory.UploadedCount, opentsdb.TagSet{"job": x.Name})
-				bosunDataPoint("cloudberry.job.job_duration", timeTaken.Seconds(), opentsdb.TagSet{"job": x.Name})
+				reportError(fmt.Errorf("could not read the start time of session %d of plan %q: %v", cbbSessionHistory.ID, x.Name, err))
+			}
+			timeFinished := timeStarted.Add(timeTaken)
+
+			//The session's own metrics are stamped with when it finished, for the outputs that can do that. A session that
+			//is still running hasn't finished, and if we couldn't read the start time then we don't know when it finished.
+			var sessionTime time.Time
+			if !timeStarted.IsZero() && cbbSessionHistory.Result != cbbResultRunning {
+				sessionTime = timeFinished
+			}
+
+			//Some stats that can be gleamed from the most recent history record. You check the the metadata at the top of this file if you want more details
+			//about what is being sent here (look up the record with the same metric name)
+			bosunSessionDataPoint("cloudberry.job.status", cbbSessionHistory.Result, opentsdb.TagSet{"job": x.Name}, sessionTime)
+			bosunSessionDataPoint("cloudberry.job.files_uploaded", cbbSessionHistory.UploadedCount, storageTags(x), sessionTime)
+			bosunSessionDataPoint("cloudberry.job.job_duration", timeTaken.Seconds(), opentsdb.TagSet{"job": x.Name}, sessionTime)
+			if !timeStarted.IsZero() {
 				bosunDataPoint("cloudberry.job.time_since_last_start", time.Since(timeStarted).Seconds(), opentsdb.TagSet{"job": x.Name})
-				bosunDataPoint("cloudberry.job.size_uploaded", cbbSessionHistory.UploadedSize, opentsdb.TagSet{"job": x.Name})
-				bosunDataPoint("cloudberry.job.size_total", cbbSessionHistory.TotalSize, opentsdb.TagSet{"job": x.Name})
-
-				//The following metrics are commented out for the time being, until we have nice regex matching rules in the config
-				//Also, the make the output so big that scollector overruns the buffer scanner.
-
-				/*
-					var cbbHistory cbbHistoryRow               //Holds a History row (which is the list of files that were backed up during a session)
-
-							//We have the basic details from the last run, now we can query the actual file operations that were undertaken during the run
-							sqlStatement = fmt.Sprintf(`SELECT %s FROM history WHERE plan_id = '%s' AND session_id = %v ORDER BY date_finished_utc ASC`, sqlstruct.Columns(cbbHistory), cbbSessionHistory.PlanID, cbbSessionHistory.ID)
-							rows, err := db.Query(sqlStatement, sqlstruct.Columns(cbbHistoryRow{}))
-							for rows.Next() {
-								err = sqlstruct.Scan(&cbbHistory, rows)
-								if err != nil {
-									fmt.Fprintln(os.Stderr, err) //If we couldn't load the row into our object, throw this to stderr so that scollector can log the error
-								} else {
-									//The Operation field in the database has a value of 0 for purged, but this doesn't really work very well in Bosun, so we change it to a -1
-									//when logging it so that it clearly shows up as a purge in the stats
-									opToSend := cbbHistory.Operation
-									if opToSend == 0 {
-										opToSend = -1
-									}
-
-									//We don't need to log the full path to the file in Bosun, so we're just going to log the file name
-									_, fileName := filepath.Split(cbbHistory.LocalPath)
-									bosunDataPoint("cloudberry.job.files", opToSend, opentsdb.TagSet{"job": x.Name, "file": fileName})
+			}
+			if finished, ok := lastFinished(sessions); ok {
+				bosunDataPoint("cloudberry.job.time_since_last_finish", time.Since(finished).Seconds(), opentsdb.TagSet{"job": x.Name})
+			}
+			bosunSessionDataPoint("cloudberry.job.size_uploaded", cbbSessionHistory.UploadedSize, storageTags(x), sessionTime)
+			bosunSessionDataPoint("cloudberry.job.size_total", cbbSessionHistory.TotalSize, storageTags(x), sessionTime)
+			sendWindowMetrics(x, cbbSessionHistory, timeStarted)
+
+			//How the recent runs went, regardless of whether the last one is still running
+			sendSessionOutcomes(x, findSessionOutcomes(sessions))
+
+			//Find the last full backup. If we got back fewer sessions than we asked for, then we have every session since the plan was created.
+			sendFullBackupMetrics(x, findFullBackupChain(sessions, len(sessions) < *sessionHistoryFlag, *fullRatioFlag, cbbBool(x.UseCompression)))
+
+			//Go through the files in the session once, passing each of them to everything that wants to look at them. The files
+			//are compared with the previous session, looking for signs of mass encryption, any failures are classified, we keep
+			//track of the largest and slowest files, and if the session is still running we see how far it has got.
+			//If we can't read the previous sessions, the ransomware indicators are worked out as if this were the first
+			//run, so that everything else about the session still gets sent
+			indicators, err := newRansomwareIndicators(db, cbbSessionHistory, previousSession, suspiciousExtensions)
+			if err != nil {
+				reportError(fmt.Errorf("could not compare plan %q with its previous sessions: %v", x.Name, err))
+				indicators, _ = newRansomwareIndicators(db, cbbSessionHistory, nil, suspiciousExtensions)
+			}
+			sessionErrs := newSessionErrors()
+			topFiles := newSessionTopFiles(*topFilesFlag)
+			progress := &sessionProgress{}
+			err = eachSessionFile(db, cbbSessionHistory.PlanID, cbbSessionHistory.ID, func(file cbbHistoryRow) {
+				indicators.addFile(file)
+				sessionErrs.addFile(file)
+				topFiles.addFile(file)
+				progress.addFile(file)
+			})
+			if err != nil {
+				reportError(err)
+				continue
+			}
+			indicators.finish(cbbSessionHistory)
+			sessionErrs.finish(cbbSessionHistory)
+			sendRansomwareIndicators(indicators, x.Name)
+			sendSessionErrors(sessionErrs, x.Name)
+			sendSessionTopFiles(topFiles, x.Name)
+			sendSessionProgress(x, cbbSessionHistory, timeStarted, progress)
+
+			//The following metrics are commented out for the time being, until we have nice regex matching rules in the config
+			//Also, the make the output so big that scollector overruns the buffer scanner.
+
+			/*
+				var cbbHistory cbbHistoryRow               //Holds a History row (which is the list of files that were backed up during a session)
+
+						//We have the basic details from the last run, now we can query the actual file operations that were undertaken during the run
+						sqlStatement = fmt.Sprintf(`SELECT %s FROM history WHERE plan_id = '%s' AND session_id = %v ORDER BY date_finished_utc ASC`, sqlstruct.Columns(cbbHistory), cbbSessionHistory.PlanID, cbbSessionHistory.ID)
+						rows, err := db.Query(sqlStatement, sqlstruct.Columns(cbbHistoryRow{}))
+						for rows.Next() {
+							err = sqlstruct.Scan(&cbbHistory, rows)
+							if err != nil {
+								fmt.Fprintln(os.Stderr, err) //If we couldn't load the row into our object, throw this to stderr so that scollector can log the error
+							} else {
+								//The Operation field in the database has a value of 0 for purged, but this doesn't really work very well in Bosun, so we change it to a -1
+								//when logging it so that it clearly shows up as a purge in the stats
+								opToSend := cbbHistory.Operation
+								if opToSend == 0 {
+									opToSend = -1
 								}
+
+								//We don't need to log the full path to the file in Bosun, so we're just going to log the file name
+								_, fileName := filepath.Split(cbbHistory.LocalPath)
+								bosunDataPoint("cloudberry.job.files", opToSend, opentsdb.TagSet{"job": x.Name, "file": fileName})
 							}
-				*/
-			}
+						}
+			*/
 		}
 	}
+
+	explainf("\nDestinations")
+	sendDestinationUsage(destinations)
+	if costing {
+		sendDestinationCosts(prices, destinationCosts)
+	}
+	return nil
+}
+
+//Write the plans out as JSON for -debug. Plans redact their own secrets when they are turned in to JSON.
+func writeDebugPlans(w io.Writer, plans []cbbBasePlan) error {
+	dump, err := json.MarshalIndent(plans, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(dump))
+	return err
 }
 
 //This processes the metadata supplied at the top of the file, and sends it to stdout, so that scollector
 //can read it and send it off. Also means that we're only sending it once, not hundreds of times, which
 //is nice.
 func sendMetadata() {
-	for thisMetricName, thisMetaData := range metaData {
-		if thisMetaData.Rate != "" {
-			marshalToStdOut(metadata.Metasend{
-				Metric: thisMetricName,
-				Name:   "rate",
-				Value:  thisMetaData.Rate,
-			})
-		}
+	//Nobody needs to see the metadata when we're explaining, it's the same every time
+	if explainOut != nil {
+		return
+	}
 
-		if thisMetaData.Unit != "" {
-			marshalToStdOut(metadata.Metasend{
-				Metric: thisMetricName,
-				Name:   "unit",
-				Value:  thisMetaData.Unit,
-			})
-		}
+	for metricName, thisMetaData := range registry {
+		//Metrics that have been renamed get their metadata under their old names as well, if they're being sent under them
+		for _, thisMetricName := range append([]string{metricName}, oldMetricNames(metricName, *metricVersionFlag)...) {
+			if thisMetaData.Rate != "" {
+				output.writeMetadata(metadata.Metasend{
+					Metric: thisMetricName,
+					Name:   "rate",
+					Value:  thisMetaData.Rate,
+				})
+			}
 
-		if thisMetaData.Desc != "" {
-			marshalToStdOut(metadata.Metasend{
-				Metric: thisMetricName,
-				Name:   "desc",
-				Value:  thisMetaData.Desc,
-			})
+			if thisMetaData.Unit != "" {
+				output.writeMetadata(metadata.Metasend{
+					Metric: thisMetricName,
+					Name:   "unit",
+					Value:  thisMetaData.Unit,
+				})
+			}
+
+			if thisMetaData.Desc != "" {
+				output.writeMetadata(metadata.Metasend{
+					Metric: thisMetricName,
+					Name:   "desc",
+					Value:  thisMetaData.Desc,
+				})
+			}
 		}
 	}
 }
@@ -176,6 +333,7 @@ func processCBBFile(path string, f os.FileInfo, ferr error) error {
 	filename = strings.ToLower(filename)
 
 	if filename == "cbbackup.db" {
+		explainf("Found the database at %s", path)
 		sqlLiteDB = path
 		return nil
 	}
@@ -188,11 +346,16 @@ func processCBBFile(path string, f os.FileInfo, ferr error) error {
 			return xErr //Can't read the file? Booo.
 		}
 
-		var x cbbBasePlan                              //Create a cbbBasePlan object to store the unmarshalled XML file
-		xml.Unmarshal(xBytes, &x)                      //Attempt to unmarshall it
+		var x cbbBasePlan                                 //Create a cbbBasePlan object to store the unmarshalled XML file
+		if err := xml.Unmarshal(xBytes, &x); err != nil { //Attempt to unmarshall it. We keep whatever we managed to read, even if it didn't all work.
+			explainf("Found plan file %s, but could not parse all of it: %v", path, err)
+		}
+		cbbPlanFiles[x.ID] = path
 		if strings.Index(x.Name, "Consistency") == 0 { //Is this a consistency check plan? If it is, put it into the consistency object, not the job object
+			explainf("Found consistency check plan %q (ID %s) in %s", x.Name, x.ID, path)
 			cbbPlansConsistency = append(cbbPlansConsistency, x)
 		} else { //Ok, put it into the backup object
+			explainf("Found backup plan %q (ID %s, type %s) in %s", x.Name, x.ID, x.Type, path)
 			cbbPlansBackups = append(cbbPlansBackups, x)
 		}
 		return nil
@@ -203,7 +366,80 @@ func processCBBFile(path string, f os.FileInfo, ferr error) error {
 //Take a metric, a value, and a tagset and output it to stdout so that scollector can receive it
 //and send it to Bosun.
 func bosunDataPoint(name string, value interface{}, t opentsdb.TagSet) {
+	sendDataPoint(name, value, t, pointTimes{})
+}
+
+//The same as bosunDataPoint, for a value that came from a session that finished at the given time. Outputs that can
+//stamp the value with when the session finished will do so.
+func bosunSessionDataPoint(name string, value interface{}, t opentsdb.TagSet, finished time.Time) {
+	sendDataPoint(name, value, t, pointTimes{Session: finished})
+}
+
+//The same as bosunDataPoint, for a counter that started counting from zero at the given time. Outputs that need to
+//know when a counter was reset, such as OTLP, are told.
+func bosunCounterDataPoint(name string, value interface{}, t opentsdb.TagSet, start time.Time) {
+	sendDataPoint(name, value, t, pointTimes{Start: start})
+}
 
+func sendDataPoint(name string, value interface{}, t opentsdb.TagSet, at pointTimes) {
+	cleanTagSet(t)
+
+	if err := registry.check(name, t); err != nil {
+		reportError(err)
+		return
+	}
+
+	if explainOut != nil {
+		explainf("    %s{%s} = %v", name, t.Tags(), value)
+		return
+	}
+
+	ts := time.Now().Unix()
+
+	//Send that metric to stdout, thanks. If it has been renamed, it might need sending under its old name too.
+	for _, metric := range append([]string{name}, oldMetricNames(name, *metricVersionFlag)...) {
+		err := output.writeDataPoint(opentsdb.DataPoint{
+			Metric:    metric,
+			Timestamp: ts,
+			Value:     value,
+			Tags:      t,
+		}, at)
+		if err != nil {
+			reportError(err)
+		}
+	}
+}
+
+//Take a metric and a tagset and send a piece of metadata about that particular time series to stdout, so that
+//scollector can send it on to Bosun alongside the data points.
+func bosunMetadata(metric string, name string, value interface{}, t opentsdb.TagSet) {
+	cleanTagSet(t)
+
+	if err := registry.check(metric, t); err != nil {
+		reportError(err)
+		return
+	}
+
+	if explainOut != nil {
+		explainf("    %s{%s} metadata %s = %q", metric, t.Tags(), name, fmt.Sprint(value))
+		return
+	}
+
+	for _, m := range append([]string{metric}, oldMetricNames(metric, *metricVersionFlag)...) {
+		err := output.writeMetadata(metadata.Metasend{
+			Metric: m,
+			Tags:   t,
+			Name:   name,
+			Value:  value,
+		})
+		if err != nil {
+			reportError(err)
+		}
+	}
+}
+
+//Get a tagset ready to be sent to Bosun.
+func cleanTagSet(t opentsdb.TagSet) {
 	//Make sure the host is correct in the tagset, or if we explicitly don't want a hostname field, delete it.
 	if host, present := t["host"]; !present {
 		t["host"] = util.Hostname
@@ -217,17 +453,6 @@ func bosunDataPoint(name string, value interface{}, t opentsdb.TagSet) {
 	for k, v := range t {
 		t[k] = escapeTagContent(v)
 	}
-
-	ts := time.Now().Unix()
-
-	//Send that metric to stdout, thanks.
-	marshalToStdOut(opentsdb.DataPoint{
-		Metric:    name,
-		Timestamp: ts,
-		Value:     value,
-		Tags:      t,
-	})
-
 }
 
 //Filenames have all sorts of stuff in them that is not valid as a Bosun tag value. We're removing everything but:
@@ -240,15 +465,3 @@ func escapeTagContent(v string) string {
 	v = invalidChars.ReplaceAllLiteralString(v, "")
 	return v
 }
-
-//Send the object to stdout in JSON form
-func marshalToStdOut(v interface{}) error {
-	bytes, err := json.Marshal(v)
-
-	if err != nil {
-		return err
-	}
-
-	fmt.Println(string(bytes))
-	return nil
-}
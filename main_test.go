@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"bosun.org/metadata"
+	"bosun.org/opentsdb"
+)
+
+//An output that keeps everything it is sent, for looking at afterwards
+type recordingOutput struct {
+	points   []opentsdb.DataPoint
+	metadata []metadata.Metasend
+}
+
+func (o *recordingOutput) writeDataPoint(dp opentsdb.DataPoint, at pointTimes) error {
+	o.points = append(o.points, dp)
+	return nil
+}
+
+func (o *recordingOutput) writeMetadata(m metadata.Metasend) error {
+	o.metadata = append(o.metadata, m)
+	return nil
+}
+
+func (o *recordingOutput) flush() error {
+	return nil
+}
+
+//The plans in the test ProgramData folder. There's a plan that runs every night and is running now, a weekly plan
+//whose last run had nothing to back up, and a consistency check.
+var testPlanFiles = map[string]string{
+	"nightly.cbb": `<?xml version="1.0" encoding="utf-8"?>
+<BasePlan xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="BackupPlan">
+  <ID>11111111-aaaa</ID>
+  <Name>File Server Nightly</Name>
+  <ConnectionID>conn-s3-1</ConnectionID>
+  <Items><PlanItem><Path>C:\data</Path></PlanItem></Items>
+  <Schedule><Enabled>true</Enabled><RecurType>Daily</RecurType><RepeatEvery>1</RepeatEvery><Hour>22</Hour><Minutes>0</Minutes><StopAfterTicks>288000000000</StopAfterTicks></Schedule>
+  <ForceFullSchedule><Enabled>true</Enabled><RecurType>Weekly</RecurType><RepeatEvery>1</RepeatEvery><Hour>1</Hour><Minutes>0</Minutes><WeekDays><DayOfWeek>Saturday</DayOfWeek></WeekDays></ForceFullSchedule>
+  <Actions><Pre><Enabled>true</Enabled><CommandLine>C:\scripts\stop-sql.cmd</CommandLine><Timeout>300</Timeout><TerminateOnFailure>true</TerminateOnFailure></Pre></Actions>
+  <Notification><SendNotification>true</SendNotification><OnlyOnFailure>true</OnlyOnFailure></Notification>
+  <UseEncryption>true</UseEncryption><EncryptionPassword>hunter2-secret</EncryptionPassword>
+  <UseStandardIA>true</UseStandardIA><UseCompression>true</UseCompression>
+  <RetentionNumberOfVersions>3</RetentionNumberOfVersions><RetentionDelay>P30D</RetentionDelay>
+</BasePlan>`,
+	"weekly.cbb": `<?xml version="1.0" encoding="utf-8"?>
+<BasePlan xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="BackupPlan">
+  <ID>22222222-bbbb</ID>
+  <Name>SQL Dumps (prod)</Name>
+  <ConnectionID>conn-s3-1</ConnectionID>
+  <Items><PlanItem><Path>E:\dumps</Path></PlanItem></Items>
+  <Schedule><Enabled>true</Enabled><RecurType>Weekly</RecurType><RepeatEvery>2</RepeatEvery><Hour>2</Hour><Minutes>30</Minutes><WeekDays><DayOfWeek>Monday</DayOfWeek><DayOfWeek>Thursday</DayOfWeek></WeekDays></Schedule>
+  <Notification><SendNotification>false</SendNotification></Notification>
+  <SSEKMSKeyID>arn:aws:kms:key/abcd</SSEKMSKeyID><UseRRS>true</UseRRS>
+</BasePlan>`,
+	"consistency.cbb": `<?xml version="1.0" encoding="utf-8"?>
+<BasePlan xsi:type="ConsistencyCheckPlan" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"><ID>333</ID><Name>Consistency check for conn-s3-1</Name></BasePlan>`,
+}
+
+type testSession struct {
+	id       int
+	planID   string
+	started  time.Time
+	duration int
+	result   int
+	uploaded float64
+	total    float64
+	message  string
+	files    []testFile
+}
+
+type testFile struct {
+	path     string
+	size     float64
+	duration int
+	message  string
+	attempts int
+}
+
+//Build a CloudBerry ProgramData folder with the test plans and a database of their sessions, relative to now
+func writeTestProgramData(t *testing.T, now time.Time) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, plan := range testPlanFiles {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(plan), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	day := 24 * time.Hour
+	files := []testFile{
+		{`C:\data\reports\q1.docx`, 1000000, 2, "", 1},
+		{`C:\data\reports\q2.xlsx`, 5000000, 9, "", 1},
+		{`C:\data\db\app.mdf`, 80000000, 40, "The process cannot access the file because it is being used by another process.", 3},
+		{`C:\data\secret\keys.txt`, 2000, 1, `Access to the path 'C:\data\secret\keys.txt' is denied.`, 1},
+	}
+	var sessions []testSession
+	for i := 10; i >= 1; i-- {
+		s := testSession{id: 11 - i, planID: "11111111-aaaa", started: now.Add(-time.Duration(i)*day - 2*time.Hour), duration: 3600, result: cbbResultSuccess, uploaded: 10000000, total: 1100000000, files: files[:2]}
+		if i == 7 {
+			s.uploaded, s.files = 500000000, files //A full backup, compressed
+		}
+		if i == 3 {
+			s.result, s.message, s.files = 8, `Access to the path C:\data\x.db is denied.`, files
+		}
+		sessions = append(sessions, s)
+	}
+	sessions = append(sessions,
+		testSession{id: 11, planID: "11111111-aaaa", started: now.Add(-20 * time.Minute), duration: 1200, result: cbbResultRunning, uploaded: 3000000, total: 1100000000, files: files[:1]},
+		testSession{id: 12, planID: "22222222-bbbb", started: now.Add(-8 * day), duration: 600, result: cbbResultSuccess, uploaded: 50000000, total: 50000000, files: []testFile{{`E:\dumps\prod.bak`, 50000000, 60, "", 1}}},
+		testSession{id: 13, planID: "22222222-bbbb", started: now.Add(-4 * day), duration: 30, result: cbbResultSuccess, total: 50000000},
+	)
+
+	writeTestDB(t, filepath.Join(dir, "cbbackup.db"), sessions)
+	return dir
+}
+
+//Write a CloudBerry database with the given sessions and their files in it
+func writeTestDB(t *testing.T, path string, sessions []testSession) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`
+		CREATE TABLE session_history(id integer primary key, destination_id int, plan_id text, date_start_utc text, duration int, result int, uploaded_count int, uploaded_size real, scanned_count int, scanned_size real, purged_count int, total_count int, total_size real, failed_count int, error_message text, processor_time int, peak_memory_usage real);
+		CREATE TABLE history(id integer primary key, destination_id int, plan_id text, local_path text, operation int, duration int, date_finished_utc text, date_modified_utc text, size real, message text, session_id int, attempts int);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range sessions {
+		_, err := db.Exec(`INSERT INTO session_history VALUES (?, 1, ?, ?, ?, ?, ?, ?, 1000, ?, 0, 1000, ?, 0, ?, 30, 100000000)`,
+			s.id, s.planID, timeToCbbTime(s.started.UTC()), s.duration, s.result, len(s.files), s.uploaded, s.total, s.total, s.message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, f := range s.files {
+			finished := s.started.Add(time.Duration(i+1) * time.Minute).UTC()
+			_, err := db.Exec(`INSERT INTO history (destination_id, plan_id, local_path, operation, duration, date_finished_utc, date_modified_utc, size, message, session_id, attempts) VALUES (1, ?, ?, 1, ?, ?, ?, ?, ?, ?, ?)`,
+				s.planID, f.path, f.duration, timeToCbbTime(finished), timeToCbbTime(finished.Add(-24*time.Hour)), f.size, f.message, s.id, f.attempts)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+//Point the collector at a ProgramData folder and a state directory, with everything it sends going to an output
+//that keeps it and every error going to errs. Everything is put back once the test is done.
+func useTestCollector(t *testing.T, programData string, stateDir string) (*recordingOutput, *bytes.Buffer) {
+	t.Helper()
+	out := &recordingOutput{}
+	errs := &bytes.Buffer{}
+
+	previousProgramData, previousDB, previousBackups, previousConsistency, previousFiles := CBProgramData, sqlLiteDB, cbbPlansBackups, cbbPlansConsistency, cbbPlanFiles
+	previousFormat, previousStateDir, previousOutput, previousErrorOut := *formatFlag, *stateDirFlag, output, errorOut
+	t.Cleanup(func() {
+		CBProgramData, sqlLiteDB, cbbPlansBackups, cbbPlansConsistency, cbbPlanFiles = previousProgramData, previousDB, previousBackups, previousConsistency, previousFiles
+		*formatFlag, *stateDirFlag, output, errorOut = previousFormat, previousStateDir, previousOutput, previousErrorOut
+		delete(outputFormats, "test")
+	})
+
+	CBProgramData, sqlLiteDB, cbbPlansBackups, cbbPlansConsistency, cbbPlanFiles = programData, "", nil, nil, make(map[string]string)
+	outputFormats["test"] = func(w io.Writer) metricOutput { return out }
+	*formatFlag, *stateDirFlag, errorOut = "test", stateDir, errs
+	return out, errs
+}
+
+//Run a whole collection against a fixture database and plans. Every metric that is sent has to be in the registry
+//with the tags it was sent with, otherwise the collector refuses to send it and reports an error, which fails this
+//test. The collection runs twice so that the second run has the state from the first one.
+func TestCollect(t *testing.T) {
+	programData := writeTestProgramData(t, time.Now())
+	stateDir := t.TempDir()
+	for run := 1; run <= 2; run++ {
+		out, errs := useTestCollector(t, programData, stateDir)
+		if err := collect(); err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+		if errs.Len() > 0 {
+			t.Errorf("run %d reported errors:\n%s", run, errs)
+		}
+
+		sent := make(map[string]bool)
+		for _, dp := range out.points {
+			sent[dp.Metric] = true
+			if _, ok := registry[dp.Metric]; !ok && !isOldMetricName(dp.Metric) {
+				t.Errorf("run %d sent %s, which isn't in the registry", run, dp.Metric)
+			}
+			if dp.Tags["host"] == "" {
+				t.Errorf("run %d sent %s without a host: %v", run, dp.Metric, dp.Tags)
+			}
+		}
+		for _, metric := range []string{
+			"cloudberry.job.count",
+			"cloudberry.jobs.count",
+			"cloudberry.job.status",
+			"cloudberry.job.time_since_last_finish",
+			"cloudberry.job.errors",
+			"cloudberry.plan.config_changes_total",
+			"cloudberry.destination.size_total",
+			"cloudberry.cost.estimated_monthly",
+		} {
+			if !sent[metric] {
+				t.Errorf("run %d didn't send %s", run, metric)
+			}
+		}
+		if len(out.metadata) == 0 {
+			t.Errorf("run %d didn't send any metadata", run)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(stateDir, planSnapshotsFile)); err != nil {
+		t.Errorf("the plan snapshots weren't saved: %v", err)
+	}
+}
+
+func isOldMetricName(name string) bool {
+	for _, r := range metricRenames {
+		if r.Old == name {
+			return true
+		}
+	}
+	return false
+}
+
+//Make sure the collector reports metrics that aren't in the registry, which is what TestCollect relies on
+func TestCollectorRefusesUndeclaredMetrics(t *testing.T) {
+	out, errs := useTestCollector(t, t.TempDir(), t.TempDir())
+	output = out
+	bosunDataPoint("cloudberry.job.not_a_metric", 1, opentsdb.TagSet{"job": "Nightly"})
+	bosunDataPoint("cloudberry.job.status", 1, opentsdb.TagSet{"jbo": "Nightly"})
+	if len(out.points) != 0 {
+		t.Errorf("sent undeclared metrics: %v", out.points)
+	}
+	if !strings.Contains(errs.String(), "not_a_metric") || !strings.Contains(errs.String(), "jbo") {
+		t.Errorf("expected both metrics to be reported, got %q", errs)
+	}
+}
+
+//Not being able to read the previous session mustn't stop everything else about the latest one being sent
+func TestCollectWithUnreadablePreviousSession(t *testing.T) {
+	programData := writeTestProgramData(t, time.Now())
+	db, err := sql.Open("sqlite3", filepath.Join(programData, "cbbackup.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`UPDATE history SET operation = 'broken' WHERE session_id = 10`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, errs := useTestCollector(t, programData, t.TempDir())
+	if err := collect(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(errs.String(), "could not compare plan \"File Server Nightly\"") {
+		t.Errorf("the unreadable previous session wasn't reported: %q", errs.String())
+	}
+
+	sent := make(map[string]bool)
+	for _, dp := range out.points {
+		if dp.Tags["job"] == escapeTagContent("File Server Nightly") {
+			sent[dp.Metric] = true
+		}
+	}
+	for _, metric := range []string{
+		"cloudberry.job.errors",
+		"cloudberry.job.largest_files",
+		"cloudberry.job.running_files_completed",
+		"cloudberry.security.suspicious_files",
+	} {
+		if !sent[metric] {
+			t.Errorf("didn't send %s", metric)
+		}
+	}
+	if sent["cloudberry.security.extension_churn"] {
+		t.Error("sent extension churn without a previous session to compare with")
+	}
+}
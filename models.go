@@ -1,26 +1,96 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
-
-	"bosun.org/metadata"
 )
 
 var cbbTimeFormat = "20060102150405"
 
+//Other formats that different versions of CloudBerry write times in. Times without a zone are always UTC.
+var cbbAltTimeFormats = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.9999999",
+	"2006-01-02 15:04:05.9999999",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+}
+
+//.NET DateTime ticks are the number of 100ns intervals since 0001-01-01 UTC. This is how many of them there are
+//before the Unix epoch.
+const dotNetTicksAtUnixEpoch = 621355968000000000
+
+//Ticks are 18 digits for any time from the year 318 to 3170. Anything else that is all digits, such as a compact
+//time with milliseconds on the end, isn't ticks. Ticks outside of these years are a misread too, and they wouldn't fit
+//in a time.Duration anyway.
+const (
+	dotNetTicksDigits   = 18
+	cbbEarliestTimeYear = 1990
+	cbbLatestTimeYear   = 2200
+)
+
+//Turn a time from the CloudBerry database in to a GoLang time, in UTC. Most versions of CloudBerry write times in the
+//compact yyyymmddhhmmss format, but some write ISO 8601 times or .NET DateTime ticks. An empty or unreadable time is
+//an error rather than the zero time, as the zero time makes everything look like it happened 2000 years ago.
 func cbbTimeToTime(cbbTime string) (time.Time, error) {
-	return time.Parse(cbbTimeFormat, cbbTime)
+	cbbTime = strings.TrimSpace(cbbTime)
+	if cbbTime == "" {
+		return time.Time{}, errors.New("empty CloudBerry time")
+	}
+
+	if isDigits(cbbTime) {
+		switch {
+		case len(cbbTime) == len(cbbTimeFormat):
+			return time.ParseInLocation(cbbTimeFormat, cbbTime, time.UTC)
+		case len(cbbTime) == dotNetTicksDigits:
+			ticks, err := strconv.ParseInt(cbbTime, 10, 64)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("could not read CloudBerry time %q as .NET ticks: %v", cbbTime, err)
+			}
+			earliest := dotNetTicks(time.Date(cbbEarliestTimeYear, 1, 1, 0, 0, 0, 0, time.UTC))
+			latest := dotNetTicks(time.Date(cbbLatestTimeYear+1, 1, 1, 0, 0, 0, 0, time.UTC))
+			if ticks < earliest || ticks >= latest {
+				return time.Time{}, fmt.Errorf("could not read CloudBerry time %q as .NET ticks: not between %d and %d", cbbTime, cbbEarliestTimeYear, cbbLatestTimeYear)
+			}
+			return time.Unix(0, 0).UTC().Add(time.Duration(ticks-dotNetTicksAtUnixEpoch) * 100), nil
+		}
+		return time.Time{}, fmt.Errorf("could not read CloudBerry time %q: unexpected number of digits", cbbTime)
+	}
+
+	for _, format := range cbbAltTimeFormats {
+		if t, err := time.ParseInLocation(format, cbbTime, time.UTC); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("could not read CloudBerry time %q: unknown format", cbbTime)
+}
+
+//The .NET DateTime ticks for a time
+func dotNetTicks(t time.Time) int64 {
+	return t.UnixNano()/100 + dotNetTicksAtUnixEpoch
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
 }
 
 func timeToCbbTime(thisTime time.Time) string {
 	return thisTime.Format(cbbTimeFormat)
 }
 
-type standardMetrics struct {
-	Rate metadata.RateType
-	Unit metadata.Unit
-	Desc string
-}
+//Session result codes that we need to act on. See cbbJobStatuses for the full list.
+const (
+	cbbResultRunning = 2
+	cbbResultSuccess = 6
+)
 
 var cbbJobStatuses = []string{
 	"unknown",          //0
@@ -35,6 +105,22 @@ var cbbJobStatuses = []string{
 	"user interrupted", //9
 }
 
+//Get the name of a session result code
+func cbbResultName(result int) string {
+	if result < 0 || result >= len(cbbJobStatuses) {
+		return "unknown"
+	}
+	return cbbJobStatuses[result]
+}
+
+//Get the name of a history operation code
+func cbbOperationName(operation int) string {
+	if operation < 0 || operation >= len(cbbHistoryOperations) {
+		return "unknown"
+	}
+	return cbbHistoryOperations[operation]
+}
+
 var cbbHistoryOperations = []string{
 	"purge",   //0
 	"backup",  //1
@@ -110,7 +196,7 @@ type cbbBasePlan struct {
 	OnlyOnFailure                               string   `xml:"Notification>OnlyOnFailure"`
 	StopAfterTicks                              string   `xml:"ForceFullSchedule>StopAfterTicks"`
 	EnabledSchedule                             string   `xml:"Schedule>Enabled"`
-	WeekDays                                    []string `xml:"Schedule>WeekDays"`
+	WeekDays                                    []string `xml:"Schedule>WeekDays>DayOfWeek"`
 	Filters                                     string   `xml:"CompressionFilter>Filters"`
 	EncryptionPassword                          string   `xml:"EncryptionPassword"`
 	Minutes                                     string   `xml:"ForceFullSchedule>Minutes"`
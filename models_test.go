@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCbbTimeToTime(t *testing.T) {
+	tests := []struct {
+		cbbTime  string
+		expected time.Time
+	}{
+		{"20260102150405", time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{" 20260102150405 ", time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"2026-01-02T15:04:05", time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"2026-01-02 15:04:05", time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"2026-01-02T15:04:05.1234567", time.Date(2026, 1, 2, 15, 4, 5, 123456700, time.UTC)},
+		{"2026-01-02 15:04:05.123", time.Date(2026, 1, 2, 15, 4, 5, 123000000, time.UTC)},
+		{"2026-01-02T15:04:05Z", time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"2026-01-02T16:04:05.5+01:00", time.Date(2026, 1, 2, 15, 4, 5, 500000000, time.UTC)},
+		{"639029630450000000", time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)}, //.NET ticks
+		{"639029630451234567", time.Date(2026, 1, 2, 15, 4, 5, 123456700, time.UTC)},
+	}
+	for _, test := range tests {
+		got, err := cbbTimeToTime(test.cbbTime)
+		if err != nil {
+			t.Errorf("cbbTimeToTime(%q): %v", test.cbbTime, err)
+		} else if !got.Equal(test.expected) || got.Location() != time.UTC {
+			t.Errorf("cbbTimeToTime(%q) = %v, expected %v", test.cbbTime, got, test.expected)
+		}
+	}
+}
+
+func TestCbbTimeToTimeErrors(t *testing.T) {
+	for _, cbbTime := range []string{
+		"",
+		"   ",
+		"yesterday",
+		"2026-13-45T99:00:00",
+		"2026010215040",       //Too short to be compact
+		"20260102150405123",   //Compact with milliseconds, 17 digits, isn't ticks
+		"626000000000000000",  //Ticks from 1984, before CloudBerry existed
+		"700000000000000000",  //Ticks from 2219
+		"6390296304500000000", //19 digits
+		"999999999999999999",  //Would overflow a time.Duration
+		"20260102150405+0000", //Not a format CloudBerry writes
+	} {
+		if got, err := cbbTimeToTime(cbbTime); err == nil {
+			t.Errorf("cbbTimeToTime(%q) = %v, expected an error", cbbTime, got)
+		}
+	}
+}
+
+func TestDotNetTicksRoundTrip(t *testing.T) {
+	when := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	if ticks := dotNetTicks(when); ticks != 639029630450000000 {
+		t.Errorf("dotNetTicks(%v) = %d", when, ticks)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"bosun.org/opentsdb"
+)
+
+//Send the notification settings for a plan. CloudBerry can email someone when a plan runs (or only when it fails)
+//and can write to the Windows event log. A production plan that doesn't email anyone when it fails is a policy
+//violation, as the only way anyone would find out about the failure is through Bosun.
+func sendNotificationSettings(plan cbbBasePlan, production *regexp.Regexp) {
+	notify := cbbBool(plan.SendNotification)
+	bosunDataPoint("cloudberry.plan.notifications_enabled", boolToInt(notify), opentsdb.TagSet{"job": plan.Name})
+	bosunDataPoint("cloudberry.plan.eventlog_enabled", boolToInt(cbbBool(plan.SendNotificationWindowsEventLogNotification)), opentsdb.TagSet{"job": plan.Name})
+
+	if notify {
+		bosunMetadata("cloudberry.plan.notifications_enabled", "only_on_failure", cbbBool(plan.OnlyOnFailure), opentsdb.TagSet{"job": plan.Name})
+		bosunMetadata("cloudberry.plan.notifications_enabled", "generate_report", cbbBool(plan.GenerateReport), opentsdb.TagSet{"job": plan.Name})
+		if subject := strings.TrimSpace(plan.Subject); subject != "" {
+			bosunMetadata("cloudberry.plan.notifications_enabled", "subject", subject, opentsdb.TagSet{"job": plan.Name})
+		}
+	}
+
+	//Both "always" and "only on failure" tell someone about a failure, so either one is fine
+	if production != nil && production.MatchString(plan.Name) {
+		bosunDataPoint("cloudberry.plan.notification_policy_violation", boolToInt(!notify), opentsdb.TagSet{"job": plan.Name})
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"bosun.org/metadata"
+	"bosun.org/opentsdb"
+	"bosun.org/util"
+)
+
+//OpenTelemetry metrics, sent to an OTLP/HTTP receiver such as the OpenTelemetry Collector using the JSON encoding of
+//OTLP, so that we don't need the protobuf libraries. Gauges in the metadata become OTel gauges and counters become
+//monotonic cumulative sums, starting from when the counter started counting, with the metadata's description and
+//unit. The host tag and the CloudBerry install go in the resource attributes, and the rest of the tags are
+//attributes of each data point. Per series metadata has nowhere to go in OTLP, so it is dropped.
+type otlpOutput struct {
+	url     string
+	client  *http.Client
+	metrics []*otlpMetric          //In the order that they were first seen
+	byName  map[string]*otlpMetric //The same metrics, keyed on their name
+}
+
+//Send to an OTLP/HTTP receiver, e.g. http://otel-collector:4318. The metrics path is added if it isn't there.
+func newOTLPOutput(url string) *otlpOutput {
+	url = strings.TrimRight(url, "/")
+	if !strings.HasSuffix(url, "/v1/metrics") {
+		url += "/v1/metrics"
+	}
+	return &otlpOutput{
+		url:    url,
+		client: &http.Client{Timeout: pushTimeout},
+		byName: make(map[string]*otlpMetric),
+	}
+}
+
+//The parts of the OTLP JSON encoding that we use. 64 bit integers are strings in the JSON encoding.
+type otlpMetricsRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope     `json:"scope"`
+	Metrics []*otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpMetric struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Unit        string     `json:"unit,omitempty"`
+	Gauge       *otlpGauge `json:"gauge,omitempty"`
+	Sum         *otlpSum   `json:"sum,omitempty"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpDataPoint `json:"dataPoints"`
+	AggregationTemporality int             `json:"aggregationTemporality"` //2 is cumulative
+	IsMonotonic            bool            `json:"isMonotonic"`
+}
+
+type otlpDataPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"` //When a cumulative sum started counting
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	AsInt             *string         `json:"asInt,omitempty"`
+	AsDouble          *float64        `json:"asDouble,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string          `json:"key"`
+	Value otlpStringValue `json:"value"`
+}
+
+type otlpStringValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+//UCUM units for the units in the metadata. Units without a UCUM equivalent are sent as an annotation, e.g. {USD}.
+//Only fractions are 1, which Prometheus exporters take to mean a ratio. Counts and booleans are annotations.
+var otlpUnits = map[metadata.Unit]string{
+	metadata.Bytes:          "By",
+	metadata.BytesPerSecond: "By/s",
+	metadata.Second:         "s",
+	metadata.Pct:            "%",
+	metadata.Timestamp:      "s",
+	metadata.Bool:           "{bool}",
+	unitFraction:            "1",
+	metadata.None:           "{count}", //Also covers counts, which have no unit
+}
+
+//Attribute names for the tags that can't be sent as they are. The OpenTelemetry Collector's Prometheus exporters set
+//the job label from service.name, so the plan's job tag would clash with it.
+var otlpAttributeNames = map[string]string{
+	"job": "cloudberry.plan",
+}
+
+//The attribute name that a tag is sent as
+func otlpAttributeName(tag string) string {
+	if name, ok := otlpAttributeNames[tag]; ok {
+		return name
+	}
+	return tag
+}
+
+//The UCUM unit for a unit in the metadata
+func otlpUnit(unit metadata.Unit) string {
+	if u, ok := otlpUnits[unit]; ok {
+		return u
+	}
+	return "{" + string(unit) + "}"
+}
+
+func (o *otlpOutput) writeDataPoint(dp opentsdb.DataPoint, at pointTimes) error {
+	point, ok := otlpPoint(dp.Value)
+	if !ok {
+		return nil
+	}
+	ts := time.Unix(dp.Timestamp, 0)
+	if !at.Session.IsZero() {
+		ts = at.Session
+	}
+	point.TimeUnixNano = strconv.FormatInt(ts.UnixNano(), 10)
+	if !at.Start.IsZero() {
+		point.StartTimeUnixNano = strconv.FormatInt(at.Start.UnixNano(), 10)
+	}
+	for _, k := range orderTags(dp.Tags, nil) {
+		if k != "host" {
+			point.Attributes = append(point.Attributes, otlpAttribute{otlpAttributeName(k), otlpStringValue{dp.Tags[k]}})
+		}
+	}
+
+	m := o.metric(dp.Metric)
+	if m.Sum != nil {
+		m.Sum.DataPoints = append(m.Sum.DataPoints, point)
+	} else {
+		m.Gauge.DataPoints = append(m.Gauge.DataPoints, point)
+	}
+	return nil
+}
+
+//Get the metric that a data point belongs to, setting it up from the metadata the first time we see it
+func (o *otlpOutput) metric(name string) *otlpMetric {
+	if m, ok := o.byName[name]; ok {
+		return m
+	}
+	meta := registry[name]
+	m := &otlpMetric{Name: name, Description: meta.Desc, Unit: otlpUnit(meta.Unit)}
+	if meta.Rate == metadata.Counter {
+		m.Sum = &otlpSum{AggregationTemporality: 2, IsMonotonic: true}
+	} else {
+		m.Gauge = &otlpGauge{}
+	}
+	o.metrics = append(o.metrics, m)
+	o.byName[name] = m
+	return m
+}
+
+func (o *otlpOutput) writeMetadata(m metadata.Metasend) error {
+	return nil
+}
+
+//Send everything that was collected in one request
+func (o *otlpOutput) flush() error {
+	if len(o.metrics) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(otlpMetricsRequest{[]otlpResourceMetrics{{
+		Resource:     otlpResource{otlpResourceAttributes()},
+		ScopeMetrics: []otlpScopeMetrics{{Scope: otlpScope{"scollector-cloudberry"}, Metrics: o.metrics}},
+	}}})
+	if err != nil {
+		return err
+	}
+	o.metrics = nil
+	o.byName = make(map[string]*otlpMetric)
+
+	if err := withRetries(func() error { return postJSON(o.client, o.url, body, true) }); err != nil {
+		return fmt.Errorf("could not send metrics to %s: %v", o.url, err)
+	}
+	return nil
+}
+
+//Describe the host and the CloudBerry install that the metrics are from. The edition comes from the name of the
+//ProgramData folder, e.g. "CloudBerry Backup Enterprise Edition" is the "Enterprise Edition". If the folder has been
+//renamed, we don't know the edition.
+func otlpResourceAttributes() []otlpAttribute {
+	instance := filepath.Base(CBProgramData)
+	attributes := []otlpAttribute{
+		{"service.name", otlpStringValue{"scollector-cloudberry"}},
+		{"host.name", otlpStringValue{util.Hostname}},
+		{"cloudberry.instance", otlpStringValue{instance}},
+	}
+	if strings.HasPrefix(instance, "CloudBerry Backup ") {
+		attributes = append(attributes, otlpAttribute{"cloudberry.edition", otlpStringValue{strings.TrimPrefix(instance, "CloudBerry Backup ")}})
+	}
+	return attributes
+}
+
+//Turn a value in to an OTLP data point. Booleans are sent as 1 and 0, and anything that isn't a number can't be sent.
+func otlpPoint(value interface{}) (otlpDataPoint, bool) {
+	var p otlpDataPoint
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := strconv.FormatInt(v.Int(), 10)
+		p.AsInt = &i
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i := strconv.FormatUint(v.Uint(), 10)
+		p.AsInt = &i
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		p.AsDouble = &f
+	case reflect.Bool:
+		i := strconv.Itoa(boolToInt(v.Bool()))
+		p.AsInt = &i
+	default:
+		return p, false
+	}
+	return p, true
+}
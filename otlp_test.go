@@ -0,0 +1,136 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"bosun.org/metadata"
+	"bosun.org/opentsdb"
+	"bosun.org/util"
+)
+
+//Send a collection to a stand-in OTLP receiver and check what it got
+func TestOTLPReceiver(t *testing.T) {
+	programData := CBProgramData
+	CBProgramData = filepath.Join(t.TempDir(), "CloudBerry Backup Enterprise Edition")
+	t.Cleanup(func() { CBProgramData = programData })
+
+	var got otlpMetricsRequest
+	var path string
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		path = r.URL.Path
+		if r.Header.Get("Content-Encoding") != "gzip" {
+			t.Errorf("request was not gzipped")
+		}
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.NewDecoder(gz).Decode(&got); err != nil {
+			t.Errorf("could not decode the request: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	collected := time.Date(2026, 1, 2, 16, 0, 0, 0, time.UTC)
+	session := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	firstSeen := time.Date(2025, 12, 1, 0, 0, 0, 0, time.UTC)
+	o := newOTLPOutput(server.URL)
+	points := []struct {
+		metric string
+		value  interface{}
+		at     pointTimes
+	}{
+		{"cloudberry.job.size_uploaded", 1048576, pointTimes{Session: session}},
+		{"cloudberry.job.job_duration", 3600.5, pointTimes{}},
+		{"cloudberry.plan.config_changes_total", 3, pointTimes{Start: firstSeen}},
+		{"cloudberry.job.size_uploaded", 2048, pointTimes{}},
+	}
+	for _, p := range points {
+		dp := opentsdb.DataPoint{Metric: p.metric, Timestamp: collected.Unix(), Value: p.value, Tags: opentsdb.TagSet{"host": util.Hostname, "job": "Nightly"}}
+		if err := o.writeDataPoint(dp, p.at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := o.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if requests != 1 || path != "/v1/metrics" {
+		t.Fatalf("got %d requests to %s, expected 1 to /v1/metrics", requests, path)
+	}
+	if len(got.ResourceMetrics) != 1 || len(got.ResourceMetrics[0].ScopeMetrics) != 1 {
+		t.Fatalf("expected one resource and one scope: %+v", got)
+	}
+
+	attributes := make(map[string]string)
+	for _, a := range got.ResourceMetrics[0].Resource.Attributes {
+		attributes[a.Key] = a.Value.StringValue
+	}
+	expectedAttributes := map[string]string{
+		"service.name":        "scollector-cloudberry",
+		"host.name":           util.Hostname,
+		"cloudberry.instance": "CloudBerry Backup Enterprise Edition",
+		"cloudberry.edition":  "Enterprise Edition",
+	}
+	for k, v := range expectedAttributes {
+		if attributes[k] != v {
+			t.Errorf("resource attribute %s = %q, expected %q", k, attributes[k], v)
+		}
+	}
+
+	metrics := got.ResourceMetrics[0].ScopeMetrics[0].Metrics
+	if len(metrics) != 3 {
+		t.Fatalf("got %d metrics, expected 3", len(metrics))
+	}
+
+	sizeUploaded := metrics[0]
+	if sizeUploaded.Name != "cloudberry.job.size_uploaded" || sizeUploaded.Unit != "By" || sizeUploaded.Gauge == nil || sizeUploaded.Sum != nil {
+		t.Errorf("size_uploaded should be a gauge in By: %+v", sizeUploaded)
+	} else if dps := sizeUploaded.Gauge.DataPoints; len(dps) != 2 {
+		t.Errorf("size_uploaded has %d data points, expected 2", len(dps))
+	} else {
+		if dps[0].AsInt == nil || *dps[0].AsInt != "1048576" || dps[0].TimeUnixNano != "1767366245000000000" {
+			t.Errorf("size_uploaded should be 1048576 stamped with the session time: %+v", dps[0])
+		}
+		if len(dps[0].Attributes) != 1 || dps[0].Attributes[0].Key != "cloudberry.plan" || dps[0].Attributes[0].Value.StringValue != "Nightly" {
+			t.Errorf("data point attributes should only have the plan: %+v", dps[0].Attributes)
+		}
+	}
+
+	duration := metrics[1]
+	if duration.Unit != "s" || duration.Gauge == nil || len(duration.Gauge.DataPoints) != 1 {
+		t.Errorf("job_duration should be a gauge in s: %+v", duration)
+	} else if dp := duration.Gauge.DataPoints[0]; dp.AsDouble == nil || *dp.AsDouble != 3600.5 || dp.TimeUnixNano != "1767369600000000000" {
+		t.Errorf("job_duration should be 3600.5 stamped with the collection time: %+v", dp)
+	}
+
+	changes := metrics[2]
+	if changes.Unit != "{count}" || changes.Sum == nil || changes.Gauge != nil || !changes.Sum.IsMonotonic || changes.Sum.AggregationTemporality != 2 {
+		t.Errorf("config_changes_total should be a monotonic cumulative sum in {count}: %+v", changes)
+	} else if dp := changes.Sum.DataPoints[0]; dp.AsInt == nil || *dp.AsInt != "3" || dp.StartTimeUnixNano != "1764547200000000000" {
+		t.Errorf("config_changes_total should be 3 counted from when the plan was first seen: %+v", dp)
+	}
+	if changes.Description == "" {
+		t.Error("config_changes_total has no description")
+	}
+}
+
+//Prometheus exporters add _ratio to gauges in 1, so only fractions can be sent in it
+func TestOTLPUnits(t *testing.T) {
+	for unit, u := range otlpUnits {
+		if (u == "1") != (unit == unitFraction) {
+			t.Errorf("%q is sent in %q, but only fractions should be in 1", unit, u)
+		}
+	}
+	if u := otlpUnit(metadata.Count); u != "{count}" {
+		t.Errorf("counts should be sent as {count}, not %q", u)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"time"
+
+	"bosun.org/opentsdb"
+)
+
+//Whether a session counts as a failure. A run that is still going hasn't failed yet, and anything else that
+//didn't succeed (including a run that someone stopped) means the data wasn't backed up.
+func isFailedSession(session cbbSessionHistoryRow) bool {
+	return session.Result != cbbResultSuccess && session.Result != cbbResultRunning
+}
+
+//The time that a session finished, which is its start time plus its duration
+func sessionFinished(session cbbSessionHistoryRow) (time.Time, error) {
+	started, err := cbbTimeToTime(session.DateStartUtc)
+	if err != nil {
+		return started, err
+	}
+	return started.Add(time.Duration(session.Duration) * time.Second), nil
+}
+
+//When the most recent session to have finished did so, from a plan's sessions, newest first. A session that is
+//still running hasn't finished, however long it has been going. Sessions with an unreadable start time are skipped.
+func lastFinished(sessions []cbbSessionHistoryRow) (time.Time, bool) {
+	for _, session := range sessions {
+		if session.Result == cbbResultRunning {
+			continue
+		}
+		if finished, err := sessionFinished(session); err == nil {
+			return finished, true
+		}
+	}
+	return time.Time{}, false
+}
+
+//The outcome of a plan's recent sessions. A plan that fails every night still has a recent last start, so this is
+//what backup SLA alerts need to look at.
+type sessionOutcomes struct {
+	LastSuccess         *cbbSessionHistoryRow //The most recent successful session, or nil if there isn't one
+	LastFailure         *cbbSessionHistoryRow //The most recent failed session, or nil if there isn't one
+	ConsecutiveFailures int                   //Number of failed sessions since the last successful one
+}
+
+//Work out the outcomes from a plan's sessions, newest first
+func findSessionOutcomes(sessions []cbbSessionHistoryRow) sessionOutcomes {
+	var o sessionOutcomes
+	for i := range sessions {
+		switch {
+		case sessions[i].Result == cbbResultSuccess:
+			if o.LastSuccess == nil {
+				o.LastSuccess = &sessions[i]
+			}
+		case isFailedSession(sessions[i]):
+			if o.LastFailure == nil {
+				o.LastFailure = &sessions[i]
+			}
+			if o.LastSuccess == nil {
+				o.ConsecutiveFailures++
+			}
+		}
+		if o.LastSuccess != nil && o.LastFailure != nil {
+			break
+		}
+	}
+	return o
+}
+
+//Send the outcome metrics for a plan
+func sendSessionOutcomes(plan cbbBasePlan, o sessionOutcomes) {
+	bosunDataPoint("cloudberry.job.consecutive_failures", o.ConsecutiveFailures, opentsdb.TagSet{"job": plan.Name})
+
+	if o.LastSuccess != nil {
+		if finished, err := sessionFinished(*o.LastSuccess); err != nil {
+			reportError(err)
+		} else {
+			bosunDataPoint("cloudberry.job.time_since_last_success", time.Since(finished).Seconds(), opentsdb.TagSet{"job": plan.Name})
+		}
+	}
+	if o.LastFailure != nil {
+		if finished, err := sessionFinished(*o.LastFailure); err != nil {
+			reportError(err)
+		} else {
+			bosunDataPoint("cloudberry.job.time_since_last_failure", time.Since(finished).Seconds(), opentsdb.TagSet{"job": plan.Name})
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLastFinished(t *testing.T) {
+	running := cbbSessionHistoryRow{Result: cbbResultRunning, DateStartUtc: "20260102150000", Duration: 600}
+	unreadable := cbbSessionHistoryRow{Result: cbbResultSuccess, DateStartUtc: "garbage", Duration: 60}
+	failed := cbbSessionHistoryRow{Result: 1, DateStartUtc: "20260101150000", Duration: 120}
+	succeeded := cbbSessionHistoryRow{Result: cbbResultSuccess, DateStartUtc: "20251231150000", Duration: 3600}
+
+	tests := []struct {
+		name     string
+		sessions []cbbSessionHistoryRow
+		expected time.Time
+		ok       bool
+	}{
+		{"running job is skipped", []cbbSessionHistoryRow{running, failed, succeeded}, time.Date(2026, 1, 1, 15, 2, 0, 0, time.UTC), true},
+		{"unreadable start is skipped", []cbbSessionHistoryRow{unreadable, succeeded}, time.Date(2025, 12, 31, 16, 0, 0, 0, time.UTC), true},
+		{"only a running job", []cbbSessionHistoryRow{running}, time.Time{}, false},
+		{"no sessions", nil, time.Time{}, false},
+	}
+	for _, test := range tests {
+		got, ok := lastFinished(test.sessions)
+		if ok != test.ok || !got.Equal(test.expected) {
+			t.Errorf("%s: got %v, %v, expected %v, %v", test.name, got, ok, test.expected, test.ok)
+		}
+	}
+}
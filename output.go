@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"bosun.org/metadata"
+	"bosun.org/opentsdb"
+)
+
+//Everything that the collector sends goes through one of these, so that the same collection can be written out in
+//whatever form the monitoring system wants.
+type metricOutput interface {
+	writeDataPoint(dp opentsdb.DataPoint, at pointTimes) error
+	writeMetadata(m metadata.Metasend) error
+	flush() error
+}
+
+//The times that a data point is about, besides when it was collected. Either can be zero.
+type pointTimes struct {
+	Session time.Time //When the session that the value came from finished, if the value is about a single session
+	Start   time.Time //When a counter started counting from zero
+}
+
+//Where the data points and metadata are going. This is OpenTSDB JSON on stdout for scollector unless another
+//format was asked for.
+var output metricOutput = &openTSDBOutput{w: os.Stdout}
+
+//The output formats that can be chosen with -format
+var outputFormats = map[string]func(w io.Writer) metricOutput{
+	"opentsdb": func(w io.Writer) metricOutput { return &openTSDBOutput{w: w} },
+	"influx":   func(w io.Writer) metricOutput { return &influxOutput{w: w} },
+	"graphite": func(w io.Writer) metricOutput {
+		return &graphiteOutput{w: w, tagOrder: parseTagOrder(*graphiteTagsFlag)}
+	},
+	"graphite-tagged": func(w io.Writer) metricOutput { return &graphiteOutput{w: w, tagged: true} },
+	"statsd":          func(w io.Writer) metricOutput { return &statsdOutput{w: w, tagOrder: parseTagOrder(*graphiteTagsFlag)} },
+}
+
+//Set up the output from the command line. Metrics are written to stdout in the chosen format, unless they're being
+//sent somewhere with -send, -push or -otlp. When we're only explaining, nothing is sent anywhere. The function that
+//is returned flushes the output and closes any connection, and needs calling once the collection is done.
+func openOutput() (metricOutput, func(), error) {
+	var sending int
+	for _, address := range []string{*sendFlag, *pushFlag, *otlpFlag} {
+		if address != "" {
+			sending++
+		}
+	}
+	if sending > 1 {
+		return nil, nil, errors.New("only one of -send, -push and -otlp can be used at a time")
+	}
+	if (*pushFlag != "" || *otlpFlag != "") && !strings.EqualFold(*formatFlag, "opentsdb") {
+		return nil, nil, errors.New("-push and -otlp have their own formats, so they can't be used with -format " + *formatFlag)
+	}
+
+	var out metricOutput
+	var w io.Writer = os.Stdout
+	closeConn := func() error { return nil }
+	if explainOut == nil {
+		switch {
+		case *pushFlag != "":
+			out = newPushOutput(*pushFlag, *stateDirFlag)
+		case *otlpFlag != "":
+			out = newOTLPOutput(*otlpFlag)
+		case *sendFlag != "":
+			conn, err := dialOutput(*sendFlag)
+			if err != nil {
+				return nil, nil, err
+			}
+			w = conn
+			closeConn = conn.Close
+		}
+	}
+	if out == nil {
+		var err error
+		if out, err = newMetricOutput(*formatFlag, w); err != nil {
+			closeConn()
+			return nil, nil, err
+		}
+	}
+
+	return out, func() {
+		if err := out.flush(); err != nil {
+			reportError(err)
+		}
+		closeConn()
+	}, nil
+}
+
+//Get the output for a format name
+func newMetricOutput(format string, w io.Writer) (metricOutput, error) {
+	newOutput, ok := outputFormats[strings.ToLower(format)]
+	if !ok {
+		var names []string
+		for name := range outputFormats {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown output format %q, expected one of %s", format, strings.Join(names, ", "))
+	}
+	return newOutput(w), nil
+}
+
+//OpenTSDB JSON, one object per line, which is what scollector reads from external collectors. scollector and Bosun
+//expect every point to be stamped with when it was collected, so session times aren't used.
+type openTSDBOutput struct {
+	w io.Writer
+}
+
+func (o *openTSDBOutput) writeDataPoint(dp opentsdb.DataPoint, at pointTimes) error {
+	return o.writeJSON(dp)
+}
+
+func (o *openTSDBOutput) writeMetadata(m metadata.Metasend) error {
+	return o.writeJSON(m)
+}
+
+func (o *openTSDBOutput) flush() error {
+	return nil
+}
+
+func (o *openTSDBOutput) writeJSON(v interface{}) error {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(o.w, string(bytes))
+	return err
+}
+
+//InfluxDB line protocol, for Telegraf's exec input or for writing straight in to InfluxDB. A metric such as
+//cloudberry.job.size_uploaded becomes the size_uploaded_bytes field of the cloudberry_job measurement, with the
+//unit from the metadata on the end of the field name. Values that came from a session are stamped with when the
+//session finished. Line protocol has nowhere to put metadata, so it is dropped.
+type influxOutput struct {
+	w io.Writer
+}
+
+func (o *influxOutput) writeDataPoint(dp opentsdb.DataPoint, at pointTimes) error {
+	ts := time.Unix(dp.Timestamp, 0)
+	if !at.Session.IsZero() {
+		ts = at.Session
+	}
+
+	measurement, field := influxName(dp.Metric)
+	var keys []string
+	for k := range dp.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	line := influxEscape(measurement, ", ")
+	for _, k := range keys {
+		line += "," + influxEscape(k, ",= ") + "=" + influxEscape(dp.Tags[k], ",= ")
+	}
+	line += " " + influxEscape(field, ",= ") + "=" + influxValue(dp.Value) + " " + strconv.FormatInt(ts.UnixNano(), 10)
+	_, err := fmt.Fprintln(o.w, line)
+	return err
+}
+
+func (o *influxOutput) writeMetadata(m metadata.Metasend) error {
+	return nil
+}
+
+func (o *influxOutput) flush() error {
+	return nil
+}
+
+//The suffix that is put on the end of a field name for each unit, so that the field says what it is measured in
+var influxUnitSuffixes = map[metadata.Unit]string{
+	metadata.Bytes:          "bytes",
+	metadata.BytesPerSecond: "bytes_per_sec",
+	metadata.Second:         "seconds",
+	metadata.Pct:            "pct",
+	metadata.Timestamp:      "timestamp",
+}
+
+//Split a metric name in to a measurement and a field, e.g. cloudberry.plan.actions.enabled becomes the
+//actions_enabled field of cloudberry_plan
+func influxName(metric string) (string, string) {
+	parts := strings.SplitN(metric, ".", 3)
+	if len(parts) < 3 {
+		return strings.Replace(metric, ".", "_", -1), "value"
+	}
+	field := strings.Replace(parts[2], ".", "_", -1)
+	if suffix, ok := influxUnitSuffixes[registry[metric].Unit]; ok && !strings.HasSuffix(field, suffix) {
+		field += "_" + suffix
+	}
+	return parts[0] + "_" + parts[1], field
+}
+
+//Put a backslash before each of the characters that line protocol doesn't allow in a name
+func influxEscape(s string, chars string) string {
+	for _, c := range chars {
+		s = strings.Replace(s, string(c), "\\"+string(c), -1)
+	}
+	return s
+}
+
+//Write a field value. Integers need an i on the end, otherwise InfluxDB stores them as floats, and then refuses
+//any float that gets sent for the same field later.
+func influxValue(value interface{}) string {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10) + "i"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10) + "i"
+	case reflect.Float32:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 32)
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64)
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	}
+	return "\"" + strings.NewReplacer("\\", "\\\\", "\"", "\\\"").Replace(fmt.Sprint(value)) + "\""
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"bosun.org/metadata"
+	"bosun.org/opentsdb"
+)
+
+//The same collection is written out in every format and compared with what that format should look like. The job
+//tag has characters in it that each format has to escape or clean, the first point came from a session so the
+//formats that can are expected to stamp it with when the session finished, and the points cover integers, floats
+//and metrics with and without units.
+func TestOutputFormats(t *testing.T) {
+	collected := time.Date(2026, 1, 2, 16, 0, 0, 0, time.UTC).Unix()
+	session := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	points := []struct {
+		dp opentsdb.DataPoint
+		at pointTimes
+	}{
+		{opentsdb.DataPoint{Metric: "cloudberry.job.size_uploaded", Timestamp: collected, Value: 1048576, Tags: opentsdb.TagSet{"host": "web01", "job": "My Plan,v1.2"}}, pointTimes{Session: session}},
+		{opentsdb.DataPoint{Metric: "cloudberry.job.job_duration", Timestamp: collected, Value: 3600.5, Tags: opentsdb.TagSet{"host": "web01", "job": "Nightly"}}, pointTimes{}},
+		{opentsdb.DataPoint{Metric: "cloudberry.job.window_utilisation", Timestamp: collected, Value: float32(0.1), Tags: opentsdb.TagSet{"host": "web01", "job": "Nightly"}}, pointTimes{}},
+		{opentsdb.DataPoint{Metric: "cloudberry.job.count", Timestamp: collected, Value: 2, Tags: opentsdb.TagSet{"host": "web01"}}, pointTimes{}},
+	}
+	meta := metadata.Metasend{Metric: "cloudberry.job.count", Name: "desc", Value: "Number of backup jobs registered."}
+
+	tests := []struct {
+		format string
+		golden string
+	}{
+		{"opentsdb", `{"metric":"cloudberry.job.size_uploaded","timestamp":1767369600,"value":1048576,"tags":{"host":"web01","job":"My Plan,v1.2"}}
+{"metric":"cloudberry.job.job_duration","timestamp":1767369600,"value":3600.5,"tags":{"host":"web01","job":"Nightly"}}
+{"metric":"cloudberry.job.window_utilisation","timestamp":1767369600,"value":0.1,"tags":{"host":"web01","job":"Nightly"}}
+{"metric":"cloudberry.job.count","timestamp":1767369600,"value":2,"tags":{"host":"web01"}}
+{"metric":"cloudberry.job.count","name":"desc","value":"Number of backup jobs registered."}
+`},
+		{"influx", `cloudberry_job,host=web01,job=My\ Plan\,v1.2 size_uploaded_bytes=1048576i 1767366245000000000
+cloudberry_job,host=web01,job=Nightly job_duration_seconds=3600.5 1767369600000000000
+cloudberry_job,host=web01,job=Nightly window_utilisation=0.1 1767369600000000000
+cloudberry_job,host=web01 count=2i 1767369600000000000
+`},
+		{"graphite", `cloudberry.job.size_uploaded.web01.My_Planv1_2 1048576 1767366245
+cloudberry.job.job_duration.web01.Nightly 3600.5 1767369600
+cloudberry.job.window_utilisation.web01.Nightly 0.1 1767369600
+cloudberry.job.count.web01 2 1767369600
+`},
+		{"graphite-tagged", `cloudberry.job.size_uploaded;host=web01;job=My_Planv1.2 1048576 1767366245
+cloudberry.job.job_duration;host=web01;job=Nightly 3600.5 1767369600
+cloudberry.job.window_utilisation;host=web01;job=Nightly 0.1 1767369600
+cloudberry.job.count;host=web01 2 1767369600
+`},
+		{"statsd", `cloudberry.job.size_uploaded.web01.My_Planv1_2:1048576|g
+cloudberry.job.job_duration.web01.Nightly:3600.5|g
+cloudberry.job.window_utilisation.web01.Nightly:0.1|g
+cloudberry.job.count.web01:2|g
+`},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		out, err := newMetricOutput(test.format, &buf)
+		if err != nil {
+			t.Fatalf("%s: %v", test.format, err)
+		}
+		for _, p := range points {
+			if err := out.writeDataPoint(p.dp, p.at); err != nil {
+				t.Fatalf("%s: %v", test.format, err)
+			}
+		}
+		if err := out.writeMetadata(meta); err != nil {
+			t.Fatalf("%s: %v", test.format, err)
+		}
+		if err := out.flush(); err != nil {
+			t.Fatalf("%s: %v", test.format, err)
+		}
+		if buf.String() != test.golden {
+			t.Errorf("%s output was:\n%s\nexpected:\n%s", test.format, buf.String(), test.golden)
+		}
+	}
+}
+
+func TestUnknownOutputFormat(t *testing.T) {
+	if _, err := newMetricOutput("xml", &bytes.Buffer{}); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestInfluxName(t *testing.T) {
+	tests := []struct {
+		metric      string
+		measurement string
+		field       string
+	}{
+		{"cloudberry.job.size_uploaded", "cloudberry_job", "size_uploaded_bytes"},
+		{"cloudberry.job.throughput_bytes_per_sec", "cloudberry_job", "throughput_bytes_per_sec"}, //Already ends in its unit
+		{"cloudberry.job.diff_size_pct", "cloudberry_job", "diff_size_pct"},
+		{"cloudberry.plan.actions.enabled", "cloudberry_plan", "actions_enabled"},
+		{"cloudberry.plan.last_config_change", "cloudberry_plan", "last_config_change_timestamp"},
+		{"cloudberry.job.count", "cloudberry_job", "count"},
+		{"single", "single", "value"},
+	}
+	for _, test := range tests {
+		measurement, field := influxName(test.metric)
+		if measurement != test.measurement || field != test.field {
+			t.Errorf("influxName(%q) = %q, %q, expected %q, %q", test.metric, measurement, field, test.measurement, test.field)
+		}
+	}
+}
+
+func TestInfluxValue(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected string
+	}{
+		{7, "7i"},
+		{int64(-3), "-3i"},
+		{uint32(4000000000), "4000000000i"},
+		{float32(0.5), "0.5"},
+		{float32(0.1), "0.1"}, //Not 0.10000000149011612, which is what it is as a float64
+		{2.25, "2.25"},
+		{true, "true"},
+		{`say "hi"\`, `"say \"hi\"\\"`},
+	}
+	for _, test := range tests {
+		if v := influxValue(test.value); v != test.expected {
+			t.Errorf("influxValue(%#v) = %s, expected %s", test.value, v, test.expected)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"bosun.org/metadata"
+	"bosun.org/opentsdb"
+)
+
+//Hosts that don't run scollector can push straight to OpenTSDB or Bosun instead. The data points and metadata are
+//kept until the end of the collection and then sent in batches. Anything that can't be sent is spooled to disk in
+//the state directory, and sent before anything else on the next run.
+const (
+	pushBatchSize     = 500              //Data points per request
+	pushRetries       = 3                //Attempts at each request before it is spooled
+	pushTimeout       = 30 * time.Second //Time allowed for each request
+	pushSpoolDir      = "spool"          //Directory in the state directory that unsent batches go in
+	pushMaxSpoolFiles = 1000             //Oldest batches are dropped past this, so a dead server can't fill the disk
+)
+
+//Wait before the first retry, doubled for each retry after that
+var pushBackoff = time.Second
+
+//The clock that spooled batches are named by. Windows' clock only ticks every few milliseconds, so batches spooled
+//one after another can have the same time, and pushSpoolSequence keeps their names apart.
+var (
+	pushNow           = time.Now
+	pushSpoolSequence int
+)
+
+//The API endpoints that each kind of batch is sent to
+var pushEndpoints = map[string]string{
+	"put":      "/api/put",
+	"metadata": "/api/metadata/put",
+}
+
+type pushOutput struct {
+	url      string
+	spoolDir string
+	client   *http.Client
+	points   []opentsdb.DataPoint
+	metadata []metadata.Metasend
+}
+
+//Push to an OpenTSDB or Bosun server, e.g. http://bosun:8070
+func newPushOutput(url string, stateDir string) *pushOutput {
+	return &pushOutput{
+		url:      strings.TrimRight(url, "/"),
+		spoolDir: filepath.Join(stateDir, pushSpoolDir),
+		client:   &http.Client{Timeout: pushTimeout},
+	}
+}
+
+func (o *pushOutput) writeDataPoint(dp opentsdb.DataPoint, at pointTimes) error {
+	o.points = append(o.points, dp)
+	return nil
+}
+
+func (o *pushOutput) writeMetadata(m metadata.Metasend) error {
+	o.metadata = append(o.metadata, m)
+	return nil
+}
+
+//Send everything that has been spooled from previous runs, then everything from this run. Once the server has
+//failed a request, everything else goes straight to the spool rather than waiting through the retries again.
+func (o *pushOutput) flush() error {
+	var batches []pushBatch
+	for i := 0; i < len(o.points); i += pushBatchSize {
+		end := i + pushBatchSize
+		if end > len(o.points) {
+			end = len(o.points)
+		}
+		body, err := json.Marshal(o.points[i:end])
+		if err != nil {
+			return err
+		}
+		batches = append(batches, pushBatch{Kind: "put", Body: body})
+	}
+	if len(o.metadata) > 0 {
+		body, err := json.Marshal(o.metadata)
+		if err != nil {
+			return err
+		}
+		batches = append(batches, pushBatch{Kind: "metadata", Body: body})
+	}
+	o.points = nil
+	o.metadata = nil
+
+	spooled, err := o.loadSpool()
+	if err != nil {
+		reportError(err)
+	}
+
+	//Anything the server rejects is dropped, as sending it again won't help. Anything that couldn't be sent because
+	//the server is down is kept in the spool for next time.
+	var down error
+	for _, b := range append(spooled, batches...) {
+		if down == nil {
+			err := o.sendWithRetries(b)
+			if err == nil || isPermanentPushError(err) {
+				if err != nil {
+					reportError(err)
+				}
+				if b.file != "" {
+					os.Remove(b.file)
+				}
+				continue
+			}
+			down = err
+		}
+		if b.file == "" {
+			if err := o.spool(b); err != nil {
+				reportError(err)
+			}
+		}
+	}
+	if down != nil {
+		return fmt.Errorf("could not push to %s, unsent data has been spooled to %s: %v", o.url, o.spoolDir, down)
+	}
+	return nil
+}
+
+//A batch of data points or metadata, ready to send
+type pushBatch struct {
+	Kind string //put or metadata
+	Body []byte //JSON array
+	file string //The spool file that the batch came from, if it came from the spool
+}
+
+//The server rejected what we sent, so sending it again won't help
+type permanentPushError struct {
+	status string
+	body   string
+}
+
+func (e permanentPushError) Error() string {
+	return fmt.Sprintf("server rejected the request: %s %s", e.status, e.body)
+}
+
+func isPermanentPushError(err error) bool {
+	_, ok := err.(permanentPushError)
+	return ok
+}
+
+//Send a batch, backing off between attempts if the server can't be reached or has a problem
+func (o *pushOutput) sendWithRetries(b pushBatch) error {
+	return withRetries(func() error { return o.send(b) })
+}
+
+//Keep trying something until it works, backing off between attempts, unless it fails in a way that trying again
+//won't fix
+func withRetries(send func() error) error {
+	wait := pushBackoff
+	var err error
+	for attempt := 1; attempt <= pushRetries; attempt++ {
+		if err = send(); err == nil || isPermanentPushError(err) {
+			return err
+		}
+		if attempt < pushRetries {
+			time.Sleep(wait)
+			wait *= 2
+		}
+	}
+	return err
+}
+
+//Send a batch once. Data points are gzipped, which /api/put understands. Bosun's /api/metadata/put doesn't, so
+//metadata goes as it is.
+func (o *pushOutput) send(b pushBatch) error {
+	return postJSON(o.client, o.url+pushEndpoints[b.Kind], b.Body, b.Kind == "put")
+}
+
+//POST a JSON body, gzipping it first if the server understands that. A 4xx response is returned as a
+//permanentPushError, as the server isn't going to like the same body any better next time.
+func postJSON(client *http.Client, url string, body []byte, gzipped bool) error {
+	if gzipped {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		if _, err := gz.Write(body); err != nil {
+			return err
+		}
+		if err := gz.Close(); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if gzipped {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	msg, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		return permanentPushError{resp.Status, strings.TrimSpace(string(msg))}
+	}
+	return fmt.Errorf("%s %s", resp.Status, strings.TrimSpace(string(msg)))
+}
+
+//Write a batch to the spool. The file name starts with the time and a sequence number so that batches are sent in
+//the order they were collected, and ends with the kind of batch so we know where to send it.
+func (o *pushOutput) spool(b pushBatch) error {
+	if err := os.MkdirAll(o.spoolDir, 0700); err != nil {
+		return err
+	}
+	pushSpoolSequence++
+	path := filepath.Join(o.spoolDir, fmt.Sprintf("%d-%06d-%s.json", pushNow().UnixNano(), pushSpoolSequence%1000000, b.Kind))
+	if err := ioutil.WriteFile(path+".tmp", b.Body, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+//Load the spooled batches, oldest first. If there are too many, the oldest are thrown away.
+func (o *pushOutput) loadSpool() ([]pushBatch, error) {
+	files, err := filepath.Glob(filepath.Join(o.spoolDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	if len(files) > pushMaxSpoolFiles {
+		for _, f := range files[:len(files)-pushMaxSpoolFiles] {
+			os.Remove(f)
+		}
+		reportError(fmt.Errorf("dropped %d spooled batches, the spool in %s is full", len(files)-pushMaxSpoolFiles, o.spoolDir))
+		files = files[len(files)-pushMaxSpoolFiles:]
+	}
+
+	var batches []pushBatch
+	for _, f := range files {
+		name := strings.TrimSuffix(filepath.Base(f), ".json")
+		kind := name[strings.LastIndex(name, "-")+1:]
+		if _, ok := pushEndpoints[kind]; !ok {
+			continue
+		}
+		body, err := ioutil.ReadFile(f)
+		if err != nil {
+			return batches, err
+		}
+		batches = append(batches, pushBatch{Kind: kind, Body: body, file: f})
+	}
+	return batches, nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bosun.org/metadata"
+	"bosun.org/opentsdb"
+)
+
+//A stand-in for OpenTSDB or Bosun that records what it was sent and answers with a status the test picks
+type pushServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	status   int
+	requests []pushRequest
+}
+
+type pushRequest struct {
+	path    string
+	gzipped bool
+	body    string
+}
+
+func newPushServer(t *testing.T, status int) *pushServer {
+	s := &pushServer{status: status}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := pushRequest{path: r.URL.Path, gzipped: r.Header.Get("Content-Encoding") == "gzip"}
+		body := r.Body
+		if req.gzipped {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Errorf("%s: body is not gzipped: %v", r.URL.Path, err)
+				return
+			}
+			body = gz
+		}
+		b, err := ioutil.ReadAll(body)
+		if err != nil {
+			t.Errorf("%s: %v", r.URL.Path, err)
+		}
+		req.body = string(b)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests = append(s.requests, req)
+		w.WriteHeader(s.status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *pushServer) received() []pushRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]pushRequest{}, s.requests...)
+}
+
+func (s *pushServer) setStatus(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+//Retries shouldn't hold the tests up
+func noPushBackoff(t *testing.T) {
+	backoff := pushBackoff
+	pushBackoff = time.Millisecond
+	t.Cleanup(func() { pushBackoff = backoff })
+}
+
+func spoolFiles(t *testing.T, o *pushOutput) []string {
+	files, err := filepath.Glob(filepath.Join(o.spoolDir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func writeTestPush(t *testing.T, o *pushOutput, metric string) {
+	dp := opentsdb.DataPoint{Metric: metric, Timestamp: 1767369600, Value: 1, Tags: opentsdb.TagSet{"host": "web01"}}
+	if err := o.writeDataPoint(dp, pointTimes{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.writeMetadata(metadata.Metasend{Metric: metric, Name: "desc", Value: "A test metric."}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPushServerErrorIsRetriedThenSpooled(t *testing.T) {
+	noPushBackoff(t)
+	server := newPushServer(t, http.StatusServiceUnavailable)
+	o := newPushOutput(server.URL, t.TempDir())
+	writeTestPush(t, o, "cloudberry.job.count")
+
+	if err := o.flush(); err == nil {
+		t.Error("expected an error when the server is down")
+	}
+	//The first batch is retried until it gives up, then everything else goes straight to the spool
+	if got := len(server.received()); got != pushRetries {
+		t.Errorf("server got %d requests, expected %d", got, pushRetries)
+	}
+	files := spoolFiles(t, o)
+	if len(files) != 2 {
+		t.Fatalf("spooled %v, expected a data point batch and a metadata batch", files)
+	}
+	if !strings.HasSuffix(files[0], "-put.json") && !strings.HasSuffix(files[1], "-put.json") {
+		t.Errorf("no data point batch in the spool: %v", files)
+	}
+}
+
+func TestPushRejectedBatchIsDropped(t *testing.T) {
+	noPushBackoff(t)
+	server := newPushServer(t, http.StatusBadRequest)
+	o := newPushOutput(server.URL, t.TempDir())
+	writeTestPush(t, o, "cloudberry.job.count")
+
+	if err := o.flush(); err != nil {
+		t.Errorf("a rejected batch shouldn't fail the flush: %v", err)
+	}
+	//Each batch is tried once and not again
+	if got := len(server.received()); got != 2 {
+		t.Errorf("server got %d requests, expected 2", got)
+	}
+	if files := spoolFiles(t, o); len(files) != 0 {
+		t.Errorf("rejected batches were spooled: %v", files)
+	}
+}
+
+func TestPushSendsSpoolFirst(t *testing.T) {
+	noPushBackoff(t)
+	server := newPushServer(t, http.StatusInternalServerError)
+	o := newPushOutput(server.URL, t.TempDir())
+	writeTestPush(t, o, "cloudberry.old")
+	if err := o.flush(); err == nil {
+		t.Fatal("expected an error when the server is down")
+	}
+	if files := spoolFiles(t, o); len(files) != 2 {
+		t.Fatalf("spooled %v, expected 2 batches", files)
+	}
+
+	server.setStatus(http.StatusNoContent)
+	failed := len(server.received())
+	writeTestPush(t, o, "cloudberry.new")
+	if err := o.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := server.received()[failed:]
+	if len(sent) != 4 {
+		t.Fatalf("server got %d requests, expected 4", len(sent))
+	}
+	for i, req := range sent {
+		expected := "cloudberry.old"
+		if i >= 2 {
+			expected = "cloudberry.new"
+		}
+		if !strings.Contains(req.body, expected) {
+			t.Errorf("request %d to %s was %s, expected %s", i, req.path, req.body, expected)
+		}
+	}
+	if files := spoolFiles(t, o); len(files) != 0 {
+		t.Errorf("sent batches were left in the spool: %v", files)
+	}
+}
+
+func TestPushEncoding(t *testing.T) {
+	server := newPushServer(t, http.StatusNoContent)
+	o := newPushOutput(server.URL+"/", t.TempDir())
+	writeTestPush(t, o, "cloudberry.job.count")
+	if err := o.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := server.received()
+	if len(sent) != 2 {
+		t.Fatalf("server got %d requests, expected 2", len(sent))
+	}
+	if sent[0].path != "/api/put" || !sent[0].gzipped {
+		t.Errorf("data points went to %s gzipped %v, expected /api/put gzipped", sent[0].path, sent[0].gzipped)
+	}
+	if sent[1].path != "/api/metadata/put" || sent[1].gzipped {
+		t.Errorf("metadata went to %s gzipped %v, expected /api/metadata/put not gzipped", sent[1].path, sent[1].gzipped)
+	}
+	if !strings.Contains(sent[0].body, `"metric":"cloudberry.job.count"`) {
+		t.Errorf("unexpected data points: %s", sent[0].body)
+	}
+}
+
+func TestPushSpoolIsCapped(t *testing.T) {
+	o := newPushOutput("http://localhost", t.TempDir())
+	if err := os.MkdirAll(o.spoolDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < pushMaxSpoolFiles+5; i++ {
+		if err := o.spool(pushBatch{Kind: "put", Body: []byte("[]")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	batches, err := o.loadSpool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != pushMaxSpoolFiles {
+		t.Errorf("loaded %d batches, expected %d", len(batches), pushMaxSpoolFiles)
+	}
+	if files := spoolFiles(t, o); len(files) != pushMaxSpoolFiles {
+		t.Errorf("%d files left in the spool, expected %d", len(files), pushMaxSpoolFiles)
+	}
+}
+
+//Batches spooled within the same tick of the clock, as happens on Windows, mustn't overwrite each other
+func TestPushSpoolNamesAreUnique(t *testing.T) {
+	now := pushNow
+	tick := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	pushNow = func() time.Time { return tick }
+	t.Cleanup(func() { pushNow = now })
+
+	o := newPushOutput("http://localhost", t.TempDir())
+	for i := 0; i < 3; i++ {
+		if err := o.spool(pushBatch{Kind: "put", Body: []byte(fmt.Sprintf("[%d]", i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	batches, err := o.loadSpool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 3 {
+		t.Fatalf("loaded %d batches, expected 3", len(batches))
+	}
+	for i, b := range batches {
+		if string(b.Body) != fmt.Sprintf("[%d]", i) || b.Kind != "put" {
+			t.Errorf("batch %d is %s %s, expected put [%d]", i, b.Kind, b.Body, i)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"bosun.org/opentsdb"
+)
+
+//Settings in the plan XML that must never be written anywhere in the clear, keyed on the cbbBasePlan field name
+var cbbSecretFields = map[string]bool{
+	"EncryptionPassword": true,
+	"SSEKMSKeyID":        true,
+}
+
+//Replace a secret with something that says whether it is set, and changes when the secret changes, without
+//giving the secret away.
+func redactSecret(v string) string {
+	if v == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(v))
+	return fmt.Sprintf("set, sha256 %x", sum[:4])
+}
+
+//Get a copy of a plan with every secret replaced by its fingerprint. Anything that writes a plan out (metadata,
+//change logs, debug output, the plan inventory) must use this rather than the plan itself.
+func (p cbbBasePlan) redacted() cbbBasePlan {
+	v := reflect.ValueOf(&p).Elem()
+	for name := range cbbSecretFields {
+		if f := v.FieldByName(name); f.IsValid() && f.Kind() == reflect.String {
+			f.SetString(redactSecret(f.String()))
+		}
+	}
+	return p
+}
+
+//Plans are redacted whenever they are printed or turned in to JSON, so that a stray fmt.Println or a new export
+//format can't leak the encryption password.
+func (p cbbBasePlan) String() string {
+	type plan cbbBasePlan //Stops fmt from calling String again
+	return fmt.Sprintf("%+v", plan(p.redacted()))
+}
+
+func (p cbbBasePlan) GoString() string {
+	type plan cbbBasePlan
+	return fmt.Sprintf("%#v", plan(p.redacted()))
+}
+
+func (p cbbBasePlan) MarshalJSON() ([]byte, error) {
+	type plan cbbBasePlan //Stops json from calling MarshalJSON again
+	return json.Marshal(plan(p.redacted()))
+}
+
+//Send whether a plan has encryption turned on and an encryption password set. This is all anyone outside of
+//CloudBerry needs to know about the password.
+func sendEncryptionSettings(plan cbbBasePlan) {
+	bosunDataPoint("cloudberry.plan.encryption_enabled", boolToInt(cbbBool(plan.UseEncryption)), opentsdb.TagSet{"job": plan.Name})
+	bosunDataPoint("cloudberry.plan.encryption_password_set", boolToInt(plan.EncryptionPassword != ""), opentsdb.TagSet{"job": plan.Name})
+}
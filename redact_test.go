@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testEncryptionPassword = "correct-horse-battery-staple"
+	testKMSKeyID           = "arn:aws:kms:eu-west-1:123456789012:key/5ec7e7-5ec7e7"
+)
+
+func secretTestPlan() cbbBasePlan {
+	return cbbBasePlan{
+		ID:                 "5f0d1c1e-0000-4000-8000-000000000001",
+		Name:               "Nightly",
+		UseEncryption:      "true",
+		EncryptionPassword: testEncryptionPassword,
+		SSEKMSKeyID:        testKMSKeyID,
+	}
+}
+
+//Fail if either secret is anywhere in what was written. Something must have been written, otherwise the test
+//proves nothing.
+func assertNoSecrets(t *testing.T, what string, written string) {
+	t.Helper()
+	if !strings.Contains(written, "Nightly") {
+		t.Errorf("%s didn't write the plan: %s", what, written)
+	}
+	for _, secret := range []string{testEncryptionPassword, testKMSKeyID} {
+		if strings.Contains(written, secret) {
+			t.Errorf("%s leaked %q: %s", what, secret, written)
+		}
+	}
+}
+
+func TestDebugPlansAreRedacted(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeDebugPlans(&buf, []cbbBasePlan{secretTestPlan()}); err != nil {
+		t.Fatal(err)
+	}
+	assertNoSecrets(t, "-debug", buf.String())
+	if !strings.Contains(buf.String(), redactSecret(testEncryptionPassword)) {
+		t.Errorf("-debug should show the password's fingerprint: %s", buf.String())
+	}
+}
+
+func TestFormattedPlansAreRedacted(t *testing.T) {
+	plan := secretTestPlan()
+	for _, verb := range []string{"%v", "%+v", "%#v", "%s"} {
+		assertNoSecrets(t, verb, fmt.Sprintf(verb, plan))
+		assertNoSecrets(t, verb+" of a pointer", fmt.Sprintf(verb, &plan))
+		assertNoSecrets(t, verb+" of a slice", fmt.Sprintf(verb, []cbbBasePlan{plan}))
+	}
+	//Redacting a copy mustn't touch the plan itself
+	if plan.EncryptionPassword != testEncryptionPassword {
+		t.Error("redacting the plan changed its password")
+	}
+}
+
+func TestJSONPlansAreRedacted(t *testing.T) {
+	plan := secretTestPlan()
+	for what, v := range map[string]interface{}{
+		"value":   plan,
+		"pointer": &plan,
+		"slice":   []cbbBasePlan{plan},
+		"map":     map[string]cbbBasePlan{plan.ID: plan},
+	} {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertNoSecrets(t, "json.Marshal of a "+what, string(b))
+	}
+}
+
+//The snapshots and change log in the state directory mustn't have the secrets in them either, including when a
+//secret is what changed.
+func TestPlanDriftIsRedacted(t *testing.T) {
+	stateDir := t.TempDir()
+	plan := secretTestPlan()
+	snapshot, err := json.Marshal(snapshotPlan(plan))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNoSecrets(t, "snapshotPlan", string(snapshot))
+
+	now := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	if _, err := trackPlanDrift([]cbbBasePlan{plan}, stateDir, now, true); err != nil {
+		t.Fatal(err)
+	}
+	changed := plan
+	changed.EncryptionPassword = "a-brand-new-password"
+	changed.SSEKMSKeyID = ""
+	if _, err := trackPlanDrift([]cbbBasePlan{changed}, stateDir, now.Add(time.Hour), true); err != nil {
+		t.Fatal(err)
+	}
+	if err := logPlanChanges(stateDir, now, plan, diffSnapshots(snapshotPlan(changed), snapshotPlan(plan))); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{planSnapshotsFile, planChangeLogFile} {
+		b, err := ioutil.ReadFile(filepath.Join(stateDir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertNoSecrets(t, file, string(b))
+		if strings.Contains(string(b), "a-brand-new-password") {
+			t.Errorf("%s leaked the new password: %s", file, b)
+		}
+	}
+}
+
+//The metrics only say whether there is a password, never what it is
+func TestEncryptionMetricsAreRedacted(t *testing.T) {
+	var buf bytes.Buffer
+	previous := output
+	output = &openTSDBOutput{w: &buf}
+	t.Cleanup(func() { output = previous })
+
+	sendEncryptionSettings(secretTestPlan())
+	assertNoSecrets(t, "the encryption metrics", buf.String())
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"bosun.org/metadata"
+	"bosun.org/opentsdb"
+)
+
+//Everything there is to know about a metric. The metadata is sent once per run, so that Bosun knows what each
+//metric is, and the tags are the only tags (other than host) that the metric may be sent with.
+type metricDef struct {
+	Rate metadata.RateType
+	Unit metadata.Unit
+	Desc string
+	Tags []string
+}
+
+//Every metric that the collector can send, keyed on name. A metric that isn't in here is refused, so that nothing
+//can be sent without metadata, and the dashboard and alert generators always know about every metric.
+type metricRegistry map[string]metricDef
+
+//Units that bosun.org/metadata doesn't have
+const (
+	unitFraction metadata.Unit = "fraction" //A ratio from 0 to 1
+	unitCurrency metadata.Unit = "currency" //Money, in the currency of the price table
+)
+
+//The version of the metric names. It goes up whenever a metric is renamed.
+const registryVersion = 2
+
+//The version of the metric names that is assumed when -metric-version isn't given. scollector runs collectors without
+//any arguments, so this is the first version, and every renamed metric is sent under its old names too. Upgrading
+//the collector then never breaks a dashboard or an alert.
+const defaultMetricVersion = 1
+
+//A metric that has been renamed, and the version that renamed it. Dashboards and alerts built on the old name keep
+//working as long as -metric-version is before the rename, which sends the metric under both names.
+type metricRename struct {
+	Old     string
+	New     string
+	Version int
+}
+
+var metricRenames = []metricRename{
+	{"cloudberry.jobs.count", "cloudberry.job.count", 2}, //Version 1 sent cloudberry.jobs.count, but the metadata was for cloudberry.job.count
+}
+
+//The registry itself. The tags need to be kept in step with the tag sets that each metric is sent with.
+var registry = metricRegistry{
+	"cloudberry.job.files":                           {metadata.Gauge, metadata.Count, "The operation taken on the file during the last job run. -1 = purged, 1 = backed up. Filenames are sanitised as such: Letters, numbers, periods and hyphens are unchanged. Slahes are converted to a hyphen, spaces are converted to underscores. All other characters are stripped.", []string{"job", "file"}},
+	"cloudberry.job.status":                          {metadata.Gauge, metadata.Count, "The last reported status of the last job run.", []string{"job"}},
+	"cloudberry.job.files_uploaded":                  {metadata.Gauge, metadata.Count, "The number of files uploaded in the last job run.", []string{"job", "storage_class", "backup_format"}},
+	"cloudberry.job.job_duration":                    {metadata.Gauge, metadata.Second, "The last reported duration of the job.", []string{"job"}},
+	"cloudberry.job.time_since_last_start":           {metadata.Gauge, metadata.Second, "Time since the job last started.", []string{"job"}},
+	"cloudberry.job.time_since_last_finish":          {metadata.Gauge, metadata.Second, "Time since the job last finished (its start time plus its duration). A run that is still going hasn't finished, so this is for the run before it.", []string{"job"}},
+	"cloudberry.job.time_since_last_success":         {metadata.Gauge, metadata.Second, "Time since the last successful run of the job finished. Not sent if none of the recent runs succeeded.", []string{"job"}},
+	"cloudberry.job.time_since_last_failure":         {metadata.Gauge, metadata.Second, "Time since the last failed run of the job finished. Runs that were stopped by a user count as failures.", []string{"job"}},
+	"cloudberry.job.consecutive_failures":            {metadata.Gauge, metadata.Count, "The number of runs of the job that have failed since the last successful run.", []string{"job"}},
+	"cloudberry.job.running":                         {metadata.Gauge, metadata.Bool, "1 if the job is running right now.", []string{"job"}},
+	"cloudberry.job.running_time":                    {metadata.Gauge, metadata.Second, "How long the running job has been going. Only sent while the job is running.", []string{"job"}},
+	"cloudberry.job.running_files_completed":         {metadata.Gauge, metadata.Count, "The number of files the running job has backed up so far. Only sent while the job is running.", []string{"job"}},
+	"cloudberry.job.running_bytes_completed":         {metadata.Gauge, metadata.Bytes, "The size of the files the running job has backed up so far. Only sent while the job is running.", []string{"job"}},
+	"cloudberry.job.running_idle_time":               {metadata.Gauge, metadata.Second, "Time since the running job last finished a file (or started, if it hasn't finished one yet). A job that is hung keeps running, but this keeps going up. Only sent while the job is running.", []string{"job"}},
+	"cloudberry.job.size_uploaded":                   {metadata.Gauge, metadata.Bytes, "The size of the data that was uploaded as reported by the last run of the job.", []string{"job", "storage_class", "backup_format"}},
+	"cloudberry.job.size_total":                      {metadata.Gauge, metadata.Bytes, "The total size of the last backup job (i.e. not just what was uploaded).", []string{"job", "storage_class", "backup_format"}},
+	"cloudberry.job.count":                           {metadata.Gauge, metadata.Count, "Number of backup jobs registered.", nil},
+	"cloudberry.job.errors":                          {metadata.Gauge, metadata.Count, "The number of failures in the last job run, grouped by error class (access_denied, file_locked, path_too_long, network, quota or other). The most recent raw error message is sent as the last_error metadata.", []string{"job", "class"}},
+	"cloudberry.job.largest_files":                   {metadata.Gauge, metadata.Bytes, "The size of the largest files in the last job run, tagged by rank (1 is the largest). The path to each file is sent as the path metadata.", []string{"job", "rank"}},
+	"cloudberry.job.slowest_files":                   {metadata.Gauge, metadata.Second, "The time taken to back up the slowest files in the last job run, tagged by rank (1 is the slowest). The path to each file is sent as the path metadata.", []string{"job", "rank"}},
+	"cloudberry.job.files_retried":                   {metadata.Gauge, metadata.Count, "The number of files in the last job run that needed more than one attempt.", []string{"job"}},
+	"cloudberry.job.throughput_bytes_per_sec":        {metadata.Gauge, metadata.BytesPerSecond, "The average upload speed of the last job run (size uploaded divided by duration).", []string{"job"}},
+	"cloudberry.job.window_utilisation":              {metadata.Gauge, unitFraction, "The fraction of the allowed backup window used by the last job run. The window is the plan's stop after limit if it has one, otherwise the time until the next scheduled run. Values approaching 1 mean the job is outgrowing its window.", []string{"job"}},
+	"cloudberry.job.stopped_by_window":               {metadata.Gauge, metadata.Bool, "1 if the last job run did not succeed and ran for as long as the plan's stop after limit, i.e. it was probably stopped for running out of time.", []string{"job"}},
+	"cloudberry.job.full_backup":                     {metadata.Gauge, metadata.Bool, "1 if the last job run was a full backup (it uploaded nearly all of the backup set), 0 if it was an incremental.", []string{"job"}},
+	"cloudberry.job.incremental_chain_length":        {metadata.Gauge, metadata.Count, "The number of successful incremental job runs since the last full backup. CloudBerry doesn't record which runs were full backups, so a run that uploaded at least -full-ratio of the backup set is taken to be one. For plans that compress their data, it is a run that uploaded at least -full-ratio of the largest upload in the last -session-history runs instead, so if none of those runs were full backups the largest incremental is mistaken for one. Not sent if no full backup was found.", []string{"job"}},
+	"cloudberry.job.time_since_last_full":            {metadata.Gauge, metadata.Second, "Time since the last full backup started. See incremental_chain_length for how full backups are found. Not sent if no full backup was found.", []string{"job"}},
+	"cloudberry.job.time_until_next_full":            {metadata.Gauge, metadata.Second, "Time until the next full backup is due according to the plan's force full schedule. Negative if it is overdue.", []string{"job"}},
+	"cloudberry.job.diff_size_pct":                   {metadata.Gauge, metadata.Pct, "The amount uploaded by incremental job runs since the last full backup, as a percentage of the full backup. Only sent for plans that force a full backup on diff size.", []string{"job"}},
+	"cloudberry.job.diff_size_limit_pct":             {metadata.Gauge, metadata.Pct, "The diff size percentage at which the plan forces a full backup.", []string{"job"}},
+	"cloudberry.plan.actions.enabled":                {metadata.Gauge, metadata.Bool, "1 if the plan's pre or post action (see the action tag) is enabled. The command line and arguments are sent as metadata.", []string{"job", "action"}},
+	"cloudberry.plan.actions.executable_exists":      {metadata.Gauge, metadata.Bool, "1 if the executable for an enabled pre or post action exists on disk. 0 means the action will fail the next time the plan runs.", []string{"job", "action"}},
+	"cloudberry.plan.actions.timeout":                {metadata.Gauge, metadata.Second, "How long CloudBerry waits for the pre or post action to finish.", []string{"job", "action"}},
+	"cloudberry.plan.actions.on_failure":             {metadata.Gauge, metadata.Bool, "For pre actions, 1 if the backup is stopped when the action fails. For post actions, 1 if the action runs even when the backup fails.", []string{"job", "action"}},
+	"cloudberry.plan.actions.command_hash":           {metadata.Gauge, metadata.Count, "A fingerprint of the pre or post action's command line and arguments. A change in value means the command has been changed.", []string{"job", "action"}},
+	"cloudberry.plan.config_changes_total":           {metadata.Counter, metadata.Count, "The number of plan settings that have changed since the collector first saw the plan. The changes are written to plan-changes.log in the collector's state directory.", []string{"job"}},
+	"cloudberry.plan.last_config_change":             {metadata.Gauge, metadata.Timestamp, "When a change to the plan's settings was last seen, or when the collector first saw the plan if it has never changed.", []string{"job"}},
+	"cloudberry.plan.storage_class":                  {metadata.Gauge, metadata.None, "The storage class that the plan writes to: 0 standard, 1 standard infrequent access, 2 reduced redundancy, 3 archive. The storage class and backup format are also sent as metadata.", []string{"job"}},
+	"cloudberry.plan.encryption_enabled":             {metadata.Gauge, metadata.Bool, "1 if the plan encrypts the data that it uploads.", []string{"job"}},
+	"cloudberry.plan.encryption_password_set":        {metadata.Gauge, metadata.Bool, "1 if the plan has an encryption password set. The password itself is never sent anywhere.", []string{"job"}},
+	"cloudberry.plan.notifications_enabled":          {metadata.Gauge, metadata.Bool, "1 if the plan sends an email notification when it runs (or only when it fails, see the only_on_failure metadata).", []string{"job"}},
+	"cloudberry.plan.eventlog_enabled":               {metadata.Gauge, metadata.Bool, "1 if the plan writes to the Windows event log when it runs.", []string{"job"}},
+	"cloudberry.plan.notification_policy_violation":  {metadata.Gauge, metadata.Bool, "1 if a production plan does not send a notification when it fails. Only sent for plans matching -production-plans.", []string{"job"}},
+	"cloudberry.destination.plans":                   {metadata.Gauge, metadata.Count, "The number of backup plans that back up to the destination.", []string{"destination"}},
+	"cloudberry.destination.size_total":              {metadata.Gauge, metadata.Bytes, "The total size of the last successful backup of every plan that backs up to the destination.", []string{"destination"}},
+	"cloudberry.destination.size_uploaded_retention": {metadata.Gauge, metadata.Bytes, "Everything uploaded to the destination during each plan's retention window.", []string{"destination"}},
+	"cloudberry.destination.size_stored_estimate":    {metadata.Gauge, metadata.Bytes, "An upper estimate of what is stored on the destination: the size of the last successful backup of each plan, plus everything uploaded during each plan's retention window.", []string{"destination"}},
+	"cloudberry.destination.versions_estimate":       {metadata.Gauge, metadata.Count, "An estimate of the number of file versions stored on the destination, limited by each plan's number of versions to keep.", []string{"destination"}},
+	"cloudberry.cost.estimated_monthly":              {metadata.Gauge, unitCurrency, "An estimate of what the plan costs to store per month, from its estimated stored size and the price of its storage class on its destination. In the currency of the price table.", []string{"job", "destination"}},
+	"cloudberry.cost.destination_estimated_monthly":  {metadata.Gauge, unitCurrency, "An estimate of what every plan on the destination costs to store per month. In the currency of the price table.", []string{"destination"}},
+	"cloudberry.security.suspicious_files":           {metadata.Gauge, metadata.Count, "The number of files in the last job run that have an extension from the suspicious extensions list (e.g. .locked, .encrypted).", []string{"job"}},
+	"cloudberry.security.modified_ratio":             {metadata.Gauge, unitFraction, "The fraction (0-1) of the backup set that was modified since the previous job run. A sudden jump can indicate mass encryption by ransomware.", []string{"job"}},
+	"cloudberry.security.extension_churn":            {metadata.Gauge, unitFraction, "The fraction (0-1) of files in the last job run whose extension was not seen at all in the last earlier job run that backed up any files. Not sent if no earlier job run backed up any files.", []string{"job"}},
+	"cloudberry.security.risk_score":                 {metadata.Gauge, metadata.Count, "A 0-100 ransomware risk score for the last job run, combining the modified ratio, suspicious files and extension churn (when there is an earlier job run to measure churn against).", []string{"job"}},
+}
+
+//Check that a metric is in the registry, and that it only has the tags that it's allowed
+func (r metricRegistry) check(name string, t opentsdb.TagSet) error {
+	def, ok := r[name]
+	if !ok {
+		return fmt.Errorf("refusing to send %s, it isn't in the metric registry", name)
+	}
+	var unknown []string
+	for k := range t {
+		if k == "host" {
+			continue
+		}
+		allowed := false
+		for _, tag := range def.Tags {
+			allowed = allowed || tag == k
+		}
+		if !allowed {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("refusing to send %s, it isn't allowed the tags %s", name, strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+//The old names that a metric should also be sent under, for the metric version that was asked for
+func oldMetricNames(name string, version int) []string {
+	var names []string
+	for _, r := range metricRenames {
+		if r.New == name && version < r.Version {
+			names = append(names, r.Old)
+		}
+	}
+	return names
+}
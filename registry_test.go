@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"bosun.org/metadata"
+)
+
+//Every metric needs a description and a rate for Bosun, and a unit for the outputs that put it on field names
+func TestRegistryIsComplete(t *testing.T) {
+	for name, def := range registry {
+		if def.Desc == "" {
+			t.Errorf("%s has no description", name)
+		}
+		if def.Rate != metadata.Gauge && def.Rate != metadata.Counter && def.Rate != metadata.Rate {
+			t.Errorf("%s has rate %q, expected a gauge, counter or rate", name, def.Rate)
+		}
+		for _, tag := range def.Tags {
+			if tag == "host" {
+				t.Errorf("%s lists the host tag, which every metric is allowed", name)
+			}
+		}
+	}
+}
+
+func TestMetricRenames(t *testing.T) {
+	for _, r := range metricRenames {
+		if _, ok := registry[r.New]; !ok {
+			t.Errorf("%s was renamed to %s, which isn't in the registry", r.Old, r.New)
+		}
+		if _, ok := registry[r.Old]; ok {
+			t.Errorf("%s was renamed, but is still in the registry", r.Old)
+		}
+		if r.Version > registryVersion {
+			t.Errorf("%s was renamed in version %d, after the current version %d", r.Old, r.Version, registryVersion)
+		}
+	}
+	if old := oldMetricNames("cloudberry.job.count", 1); len(old) != 1 || old[0] != "cloudberry.jobs.count" {
+		t.Errorf("version 1 should send cloudberry.jobs.count too, got %v", old)
+	}
+	if old := oldMetricNames("cloudberry.job.count", registryVersion); len(old) != 0 {
+		t.Errorf("the current version shouldn't send any old names, got %v", old)
+	}
+}
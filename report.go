@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//A report on every backup plan over a date range, for auditors who want to see that the backups ran
+type backupReport struct {
+	From      time.Time //The start of the first day of the report
+	To        time.Time //The end of the last day of the report, which is midnight at the start of the next day
+	Generated time.Time
+	Plans     []planReport
+}
+
+//How a single plan did over the report's date range. Runs that were still going when the report was made aren't
+//counted.
+type planReport struct {
+	ID               string
+	Name             string
+	Runs             int
+	Successes        int
+	SuccessRate      float64 //Percentage of runs that succeeded
+	UploadedSize     float64 //Bytes
+	LongestRun       float64 //Seconds
+	LongestRunStart  time.Time
+	LastSuccess      time.Time //The last success at or before the end of the report, even if it was before the start
+	Failures         []reportFailure
+	unreadableStarts int
+}
+
+//A run that failed, and why
+type reportFailure struct {
+	Start  time.Time
+	Result string
+	Error  string
+}
+
+func reportCommand(args []string) error {
+	fs, asJSON := newCommandFlags("report")
+	from := fs.String("from", "", "The first day of the report, as yyyy-mm-dd in UTC. Defaults to 7 days before -to.")
+	to := fs.String("to", "", "The last day of the report, as yyyy-mm-dd in UTC. Defaults to today.")
+	format := fs.String("format", "html", "The format of the report: json, csv or html.")
+	fs.Parse(args)
+	if *asJSON {
+		*format = "json"
+	}
+
+	r := backupReport{Generated: time.Now().UTC()}
+	r.To = time.Date(r.Generated.Year(), r.Generated.Month(), r.Generated.Day(), 0, 0, 0, 0, time.UTC)
+	if *to != "" {
+		t, err := time.Parse("2006-01-02", *to)
+		if err != nil {
+			return fmt.Errorf("could not read -to %q, expected yyyy-mm-dd", *to)
+		}
+		r.To = t
+	}
+	r.To = r.To.AddDate(0, 0, 1) //The report includes the whole of the last day
+	r.From = r.To.AddDate(0, 0, -7)
+	if *from != "" {
+		t, err := time.Parse("2006-01-02", *from)
+		if err != nil {
+			return fmt.Errorf("could not read -from %q, expected yyyy-mm-dd", *from)
+		}
+		r.From = t
+	}
+	if !r.From.Before(r.To) {
+		return fmt.Errorf("-from needs to be before -to")
+	}
+
+	db, err := openForCommand()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for _, plan := range cbbPlansBackups {
+		p := planReport{ID: plan.ID, Name: plan.Name}
+		err := eachPlanSession(db, plan.ID, func(s cbbSessionHistoryRow) {
+			p.addSession(s, r.From, r.To)
+		})
+		if err != nil {
+			return err
+		}
+		if p.unreadableStarts > 0 {
+			fmt.Fprintf(os.Stderr, "Skipped %d runs of %q with start times that could not be read\n", p.unreadableStarts, plan.Name)
+		}
+		if p.Runs > 0 {
+			p.SuccessRate = 100 * float64(p.Successes) / float64(p.Runs)
+		}
+		r.Plans = append(r.Plans, p)
+	}
+
+	switch strings.ToLower(*format) {
+	case "json":
+		return writeJSON(r)
+	case "csv":
+		return writeReportCSV(r)
+	case "html":
+		return reportTemplate.Execute(os.Stdout, r)
+	}
+	return fmt.Errorf("unknown report format %q, expected json, csv or html", *format)
+}
+
+//Add a session to a plan's report, if it started in the report's date range
+func (p *planReport) addSession(s cbbSessionHistoryRow, from, to time.Time) {
+	started, err := cbbTimeToTime(s.DateStartUtc)
+	if err != nil {
+		p.unreadableStarts++
+		return
+	}
+	if started.After(to) || started.Equal(to) {
+		return
+	}
+	if s.Result == cbbResultSuccess && started.After(p.LastSuccess) {
+		p.LastSuccess = started
+	}
+	if started.Before(from) || s.Result == cbbResultRunning {
+		return
+	}
+
+	p.Runs++
+	p.UploadedSize += float64(s.UploadedSize)
+	if float64(s.Duration) > p.LongestRun {
+		p.LongestRun = float64(s.Duration)
+		p.LongestRunStart = started
+	}
+	if !isFailedSession(s) {
+		p.Successes++
+		return
+	}
+	p.Failures = append(p.Failures, reportFailure{started, cbbResultName(s.Result), strings.TrimSpace(s.ErrorMessage)})
+}
+
+//Write one row per plan. CSV can't hold the list of failures, so there is the number of them and the most recent
+//error message instead.
+func writeReportCSV(r backupReport) error {
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"Plan", "Plan ID", "Runs", "Successes", "Success Rate (%)", "Uploaded (bytes)", "Longest Run (seconds)", "Longest Run Start (UTC)", "Last Success (UTC)", "Failures", "Last Error"})
+	for _, p := range r.Plans {
+		var lastError string
+		var lastFailure time.Time
+		for _, f := range p.Failures {
+			if f.Error != "" && f.Start.After(lastFailure) {
+				lastError = f.Error
+				lastFailure = f.Start
+			}
+		}
+		w.Write([]string{
+			p.Name,
+			p.ID,
+			strconv.Itoa(p.Runs),
+			strconv.Itoa(p.Successes),
+			strconv.FormatFloat(p.SuccessRate, 'f', 1, 64),
+			strconv.FormatFloat(p.UploadedSize, 'f', 0, 64),
+			strconv.FormatFloat(p.LongestRun, 'f', 0, 64),
+			formatReportTime(p.LongestRunStart),
+			formatReportTime(p.LastSuccess),
+			strconv.Itoa(len(p.Failures)),
+			lastError,
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+//Format a time in the report, leaving it blank if there isn't one
+func formatReportTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"time":     formatReportTime,
+	"bytes":    formatBytes,
+	"duration": func(seconds float64) string { return (time.Duration(seconds) * time.Second).String() },
+	"date":     func(t time.Time) string { return t.Format("2006-01-02") },
+	"lastDay":  func(t time.Time) string { return t.AddDate(0, 0, -1).Format("2006-01-02") },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>CloudBerry backup report {{date .From}} to {{lastDay .To}}</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+.failed { color: #b00; }
+</style>
+</head>
+<body>
+<h1>CloudBerry backup report</h1>
+<p>{{date .From}} to {{lastDay .To}} (UTC), generated {{time .Generated}}</p>
+<table>
+<tr><th>Plan</th><th>Runs</th><th>Success rate</th><th>Uploaded</th><th>Longest run</th><th>Last success (UTC)</th><th>Failures</th></tr>
+{{range .Plans}}<tr>
+<td>{{.Name}}</td><td>{{.Runs}}</td><td>{{printf "%.1f" .SuccessRate}}%</td><td>{{bytes .UploadedSize}}</td>
+<td>{{duration .LongestRun}}{{if not .LongestRunStart.IsZero}} ({{time .LongestRunStart}}){{end}}</td>
+<td>{{if .LastSuccess.IsZero}}<span class="failed">Never</span>{{else}}{{time .LastSuccess}}{{end}}</td>
+<td{{if .Failures}} class="failed"{{end}}>{{len .Failures}}</td>
+</tr>
+{{end}}</table>
+{{range .Plans}}{{if .Failures}}<h2>Failures of {{.Name}}</h2>
+<table>
+<tr><th>Started (UTC)</th><th>Result</th><th>Error</th></tr>
+{{range .Failures}}<tr><td>{{time .Start}}</td><td>{{.Result}}</td><td>{{.Error}}</td></tr>
+{{end}}</table>
+{{end}}{{end}}</body>
+</html>
+`))
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+//Write out Bosun alert rules for the metrics that the collector sends, with staleness thresholds that match how
+//often each plan is scheduled to run. A daily plan should be caught the day it misses a run, but a weekly plan
+//shouldn't alert six days in to its week.
+func bosunRulesCommand(args []string) error {
+	fs, _ := newCommandFlags("bosun-rules")
+	grace := fs.Duration("grace", 2*time.Hour, "How long past its longest gap between scheduled runs a plan can go without a success before it alerts.")
+	defaultMaxAge := fs.Duration("default-max-age", 26*time.Hour, "How long plans without a repeating schedule can go without a success before they alert.")
+	failures := fs.Int("failures", 3, "The number of failed runs in a row that is critical. A single failure is a warning.")
+	email := fs.String("email", "", "Email address to send the alerts to. If this isn't given, the alerts have no notification and you can add your own.")
+	fs.Parse(args)
+
+	if err := discoverCloudBerry(); err != nil {
+		return err
+	}
+	return writeBosunRules(os.Stdout, cbbPlansBackups, *grace, *defaultMaxAge, *failures, *email)
+}
+
+//Write the rules. Everything is named cloudberry so that it's easy to find in a Bosun config with other rules in it.
+func writeBosunRules(w io.Writer, plans []cbbBasePlan, grace time.Duration, defaultMaxAge time.Duration, failures int, email string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Bosun rules for scollector-cloudberry, generated %s\n", time.Now().UTC().Format("2006-01-02 15:04:05 UTC"))
+	fmt.Fprintf(&b, "# Paste these in to your Bosun config, and regenerate them when plans are added or their schedules change.\n\n")
+
+	b.WriteString("macro cloudberry_alert {\n")
+	b.WriteString("\ttemplate = cloudberry\n")
+	b.WriteString("\tignoreUnknown = true\n")
+	if email != "" {
+		b.WriteString("\twarnNotification = cloudberry\n")
+		b.WriteString("\tcritNotification = cloudberry\n")
+	}
+	b.WriteString("}\n\n")
+
+	if email != "" {
+		fmt.Fprintf(&b, "notification cloudberry {\n\temail = %s\n}\n\n", email)
+	}
+
+	b.WriteString("template cloudberry {\n")
+	b.WriteString("\tsubject = {{.Last.Status}}: {{.Alert.Name}} for CloudBerry plan {{.Group.job}} on {{.Group.host}}\n")
+	b.WriteString("\tbody = `<p>{{.Alert.Name}} is {{.Last.Status}} for the CloudBerry plan <b>{{.Group.job}}</b> on <b>{{.Group.host}}</b>.</p>\n")
+	b.WriteString("\t<p>Value: {{.Eval .Alert.Vars.value}}</p>\n")
+	b.WriteString("\t<p>Run <code>scollector-cloudberry sessions -plan \"{{.Group.job}}\"</code> on the host to see its recent runs.</p>`\n")
+	b.WriteString("}\n\n")
+
+	//The longest that each plan can go without a success, from its schedule. Plans without a repeating schedule get
+	//the default, which is the last entry so that it only matches what nothing else did.
+	b.WriteString("lookup cloudberry_max_age {\n")
+	for _, plan := range plans {
+		schedule := plan.schedule()
+		interval := schedule.interval()
+		fmt.Fprintf(&b, "\t# %s: %s", plan.Name, schedule.summary())
+		if interval == 0 {
+			b.WriteString(", no repeating schedule so it uses the default\n")
+			continue
+		}
+		maxAge := interval + schedule.StopAfter + grace
+		fmt.Fprintf(&b, ", longest gap between runs %s\n", interval)
+		fmt.Fprintf(&b, "\tentry host=*,job=%s {\n\t\tmax_age = %.0f\n\t}\n", escapeTagContent(plan.Name), maxAge.Seconds())
+	}
+	fmt.Fprintf(&b, "\tentry host=*,job=* {\n\t\tmax_age = %.0f\n\t}\n", defaultMaxAge.Seconds())
+	b.WriteString("}\n\n")
+
+	alerts := []struct {
+		Name  string
+		Query string
+		Warn  string
+		Crit  string
+	}{
+		{"cloudberry.job.stale", `max(q("max:cloudberry.job.time_since_last_success{host=*,job=*}", "2h", ""))`,
+			`$value > lookup("cloudberry_max_age", "max_age")`, `$value > 2 * lookup("cloudberry_max_age", "max_age")`},
+		{"cloudberry.job.failing", `last(q("max:cloudberry.job.consecutive_failures{host=*,job=*}", "2h", ""))`,
+			`$value > 0`, fmt.Sprintf(`$value >= %d`, failures)},
+		{"cloudberry.job.hung", `last(q("max:cloudberry.job.running_idle_time{host=*,job=*}", "2h", ""))`,
+			`$value > 3600`, `$value > 4 * 3600`},
+		{"cloudberry.security.ransomware", `last(q("max:cloudberry.security.risk_score{host=*,job=*}", "2h", ""))`,
+			`$value >= 50`, `$value >= 80`},
+		{"cloudberry.plan.notification_policy", `last(q("max:cloudberry.plan.notification_policy_violation{host=*,job=*}", "2h", ""))`,
+			`$value > 0`, ""},
+	}
+	for _, a := range alerts {
+		fmt.Fprintf(&b, "alert %s {\n\tmacro = cloudberry_alert\n\t$value = %s\n\twarn = %s\n", a.Name, a.Query, a.Warn)
+		if a.Crit != "" {
+			fmt.Fprintf(&b, "\tcrit = %s\n", a.Crit)
+		}
+		b.WriteString("}\n\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"time"
+
+	"bosun.org/opentsdb"
+)
+
+//How far a running session has got, from the history records it has written so far. A session that hangs keeps
+//its running status for as long as it's hung, so the time since it last finished a file is the thing to watch.
+type sessionProgress struct {
+	Files        int       //Files backed up so far
+	Bytes        float64   //Size of the files backed up so far
+	LastFinished time.Time //When the most recent file finished
+}
+
+//Count a single file from the session towards its progress
+func (p *sessionProgress) addFile(file cbbHistoryRow) {
+	if file.Operation == 1 {
+		p.Files++
+		p.Bytes += float64(file.Size)
+	}
+	if finished, err := cbbTimeToTime(file.DateFinishedUtc); err == nil && finished.After(p.LastFinished) {
+		p.LastFinished = finished
+	}
+}
+
+//Send whether the latest session of a plan is still running, and if it is, how long it has been going and how far
+//it has got
+func sendSessionProgress(plan cbbBasePlan, session cbbSessionHistoryRow, started time.Time, p *sessionProgress) {
+	running := session.Result == cbbResultRunning
+	bosunDataPoint("cloudberry.job.running", boolToInt(running), opentsdb.TagSet{"job": plan.Name})
+	if !running {
+		return
+	}
+
+	if !started.IsZero() {
+		bosunDataPoint("cloudberry.job.running_time", time.Since(started).Seconds(), opentsdb.TagSet{"job": plan.Name})
+	}
+	bosunDataPoint("cloudberry.job.running_files_completed", p.Files, opentsdb.TagSet{"job": plan.Name})
+	bosunDataPoint("cloudberry.job.running_bytes_completed", p.Bytes, opentsdb.TagSet{"job": plan.Name})
+
+	//If it hasn't finished a file yet, it has been idle since it started
+	lastActivity := p.LastFinished
+	if lastActivity.IsZero() {
+		lastActivity = started
+	}
+	if !lastActivity.IsZero() {
+		bosunDataPoint("cloudberry.job.running_idle_time", time.Since(lastActivity).Seconds(), opentsdb.TagSet{"job": plan.Name})
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//A CloudBerry plan has two schedules in it, the normal schedule that says when the plan runs, and the force
+//full schedule that says when a run should be a full backup rather than an incremental one. They are written
+//to the plan XML in exactly the same format, so both of them get read in to one of these.
+type cbbSchedule struct {
+	Enabled               bool
+	RecurType             string         //Once, Daily, Weekly, Monthly or DayOfMonth
+	RepeatEvery           int            //Run every N days/weeks/months
+	OnceDate              time.Time      //When a Once schedule runs
+	Hour                  int            //Time of day that the plan runs
+	Minutes               int            //
+	Seconds               int            //
+	WeekDays              []time.Weekday //Days that a Weekly schedule runs on
+	DayOfWeek             time.Weekday   //Day that a Monthly schedule runs on, combined with WeekNumber
+	WeekNumber            string         //First, Second, Third, Fourth or Last
+	DayOfMonth            int            //Day that a DayOfMonth schedule runs on
+	DailyRecurrence       bool           //Whether the plan runs more than once on the days that it runs
+	DailyRecurrencePeriod int            //Minutes between runs when DailyRecurrence is set
+	DailyFrom             time.Duration  //Time of day that the first run of the day happens when DailyRecurrence is set
+	DailyTill             time.Duration  //Time of day after which there are no more runs when DailyRecurrence is set
+	StopAfter             time.Duration  //How long the plan is allowed to run for before it is stopped. 0 if there is no limit
+}
+
+//Get the normal schedule for a plan
+func (p cbbBasePlan) schedule() cbbSchedule {
+	return newCbbSchedule(p.EnabledSchedule, p.RecurTypeSchedule, p.RepeatEverySchedule, p.OnceDateSchedule,
+		p.HourSchedule, p.MinutesSchedule, p.SecondsSchedule, p.WeekDays, p.DayOfWeekSchedule, p.WeekNumberSchedule,
+		p.DayOfMonthSchedule, p.DailyRecurrenceSchedule, p.DailyRecurrencePeriodSchedule, p.DailyFromHourSchedule,
+		p.DailyFromMinutesSchedule, p.DailyTillHourSchedule, p.DailyTillMinutesSchedule, p.StopAfterTicksSchedule)
+}
+
+//Get the force full schedule for a plan
+func (p cbbBasePlan) forceFullSchedule() cbbSchedule {
+	return newCbbSchedule(p.Enabled, p.RecurType, p.RepeatEvery, p.OnceDate,
+		p.Hour, p.Minutes, p.Seconds, p.DayOfWeek, p.DayOfWeekForceFullSchedule, p.WeekNumber,
+		p.DayOfMonth, p.DailyRecurrence, p.DailyRecurrencePeriod, p.DailyFromHour,
+		p.DailyFromMinutes, p.DailyTillHour, p.DailyTillMinutes, p.StopAfterTicks)
+}
+
+//Turn the strings from the plan XML in to a schedule. Anything that is missing or can't be read is left as
+//its zero value.
+func newCbbSchedule(enabled, recurType, repeatEvery, onceDate, hour, minutes, seconds string, weekDays []string, dayOfWeek, weekNumber,
+	dayOfMonth, dailyRecurrence, dailyRecurrencePeriod, dailyFromHour, dailyFromMinutes, dailyTillHour, dailyTillMinutes, stopAfterTicks string) cbbSchedule {
+	s := cbbSchedule{
+		Enabled:               cbbBool(enabled),
+		RecurType:             strings.TrimSpace(recurType),
+		RepeatEvery:           cbbInt(repeatEvery),
+		Hour:                  cbbInt(hour),
+		Minutes:               cbbInt(minutes),
+		Seconds:               cbbInt(seconds),
+		WeekNumber:            strings.TrimSpace(weekNumber),
+		DayOfMonth:            cbbInt(dayOfMonth),
+		DailyRecurrence:       cbbBool(dailyRecurrence),
+		DailyRecurrencePeriod: cbbInt(dailyRecurrencePeriod),
+		DailyFrom:             time.Duration(cbbInt(dailyFromHour))*time.Hour + time.Duration(cbbInt(dailyFromMinutes))*time.Minute,
+		DailyTill:             time.Duration(cbbInt(dailyTillHour))*time.Hour + time.Duration(cbbInt(dailyTillMinutes))*time.Minute,
+		StopAfter:             ticksToDuration(stopAfterTicks),
+	}
+	if s.RepeatEvery < 1 {
+		s.RepeatEvery = 1
+	}
+	if s.DailyTill == 0 {
+		s.DailyTill = 24 * time.Hour
+	}
+	s.OnceDate, _ = time.ParseInLocation("2006-01-02T15:04:05", strings.TrimSpace(onceDate), time.Local)
+	for _, d := range weekDays {
+		if wd, ok := cbbWeekday(d); ok {
+			s.WeekDays = append(s.WeekDays, wd)
+		}
+	}
+	s.DayOfWeek, _ = cbbWeekday(dayOfWeek)
+	return s
+}
+
+//Work out when a schedule will next run after a run at the given time, or false if it won't run again. Plans that
+//run every N days/weeks/months run on the rest of their days in the period of the last run first, and then skip to
+//the period N periods after it, as the plan doesn't tell us which period it started counting from. Weeks start on
+//Sunday. Schedule times are in the local time of the server, like the CloudBerry UI.
+func (s cbbSchedule) next(after time.Time) (time.Time, bool) {
+	if !s.Enabled {
+		return time.Time{}, false
+	}
+	after = after.In(time.Local)
+
+	switch s.RecurType {
+	case "Once":
+		return s.OnceDate, s.OnceDate.After(after)
+	case "Daily", "Weekly", "Monthly", "DayOfMonth":
+	default:
+		return time.Time{}, false
+	}
+
+	//A weekly plan that runs on Monday and Tuesday runs on the Tuesday after a Monday run, whatever RepeatEvery is
+	if run, ok := s.firstRun(after, s.periodStart(after, 1)); ok {
+		return run, true
+	}
+	return s.firstRun(s.periodStart(after, s.RepeatEvery).Add(-time.Nanosecond), time.Time{})
+}
+
+//Get the start of the period (day, week or month, depending on the schedule) that is n periods after the one that
+//the given time is in
+func (s cbbSchedule) periodStart(t time.Time, n int) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+	switch s.RecurType {
+	case "Weekly":
+		return day.AddDate(0, 0, 7*n-int(day.Weekday()))
+	case "Monthly", "DayOfMonth":
+		return time.Date(t.Year(), t.Month()+time.Month(n), 1, 0, 0, 0, 0, time.Local)
+	}
+	return day.AddDate(0, 0, n)
+}
+
+//Find the first run after the given time, and before the end time if there is one. A schedule can't go more than a
+//year without running (short of a DayOfMonth of 31 in a very unlucky year), so stop looking after that.
+func (s cbbSchedule) firstRun(after time.Time, end time.Time) (time.Time, bool) {
+	day := time.Date(after.Year(), after.Month(), after.Day(), 0, 0, 0, 0, time.Local)
+	for i := 0; i < 400 && (end.IsZero() || day.Before(end)); i++ {
+		if s.runsOn(day) {
+			for _, run := range s.runsDuring(day) {
+				if run.After(after) && (end.IsZero() || run.Before(end)) {
+					return run, true
+				}
+			}
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	return time.Time{}, false
+}
+
+//The longest time that we'd expect to see between two runs of the schedule, or 0 if it doesn't repeat. This
+//is what staleness alerts should be based on, a weekly plan that runs on Monday and Tuesday is six days
+//between runs at worst, not three and a half.
+func (s cbbSchedule) interval() time.Duration {
+	if !s.Enabled {
+		return 0
+	}
+	var period time.Duration
+	switch s.RecurType {
+	case "Daily":
+		period = 24 * time.Hour
+	case "Weekly":
+		period = 7 * 24 * time.Hour
+	case "Monthly", "DayOfMonth":
+		//Months aren't all the same length, so look at a whole year to find the longest gap
+		period = 366 * 24 * time.Hour
+	default:
+		return 0
+	}
+
+	//Take the biggest gap between runs over a full period, starting from the first run we can find
+	from, ok := s.next(time.Now().AddDate(0, 0, -1))
+	if !ok {
+		return 0
+	}
+	var longest time.Duration
+	for run := from; run.Sub(from) < period*time.Duration(s.RepeatEvery); {
+		next, ok := s.next(run)
+		if !ok {
+			break
+		}
+		if gap := next.Sub(run); gap > longest {
+			longest = gap
+		}
+		run = next
+	}
+	return longest
+}
+
+//Describe a schedule for a person, e.g. "Weekly on Monday, Thursday at 02:30"
+func (s cbbSchedule) summary() string {
+	if !s.Enabled {
+		return "Disabled"
+	}
+
+	at := fmt.Sprintf("at %02d:%02d", s.Hour, s.Minutes)
+	if s.DailyRecurrence && s.DailyRecurrencePeriod > 0 {
+		at = fmt.Sprintf("every %d minutes from %s to %s", s.DailyRecurrencePeriod, formatTimeOfDay(s.DailyFrom), formatTimeOfDay(s.DailyTill))
+	}
+
+	var summary string
+	switch s.RecurType {
+	case "Once":
+		return "Once on " + s.OnceDate.Format("2006-01-02 15:04")
+	case "Daily":
+		summary = plural(s.RepeatEvery, "Daily", "days")
+	case "Weekly":
+		var days []string
+		for _, wd := range s.WeekDays {
+			days = append(days, wd.String())
+		}
+		summary = plural(s.RepeatEvery, "Weekly", "weeks") + " on " + strings.Join(days, ", ")
+	case "Monthly":
+		summary = plural(s.RepeatEvery, "Monthly", "months") + fmt.Sprintf(" on the %s %s", strings.ToLower(s.WeekNumber), s.DayOfWeek)
+	case "DayOfMonth":
+		summary = plural(s.RepeatEvery, "Monthly", "months") + fmt.Sprintf(" on day %d", s.DayOfMonth)
+	default:
+		return s.RecurType
+	}
+	return summary + " " + at
+}
+
+//"Daily" for a schedule that runs every day, "Every 2 days" for one that runs every other day
+func plural(n int, one string, many string) string {
+	if n <= 1 {
+		return one
+	}
+	return fmt.Sprintf("Every %d %s", n, many)
+}
+
+//Format an offset from midnight as hh:mm
+func formatTimeOfDay(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+}
+
+//Check whether the schedule runs at some point on the given day
+func (s cbbSchedule) runsOn(day time.Time) bool {
+	switch s.RecurType {
+	case "Daily":
+		return true
+	case "Weekly":
+		for _, wd := range s.WeekDays {
+			if day.Weekday() == wd {
+				return true
+			}
+		}
+		return false
+	case "Monthly":
+		if day.Weekday() != s.DayOfWeek {
+			return false
+		}
+		switch s.WeekNumber {
+		case "First":
+			return day.Day() <= 7
+		case "Second":
+			return day.Day() > 7 && day.Day() <= 14
+		case "Third":
+			return day.Day() > 14 && day.Day() <= 21
+		case "Fourth":
+			return day.Day() > 21 && day.Day() <= 28
+		case "Last":
+			return day.AddDate(0, 0, 7).Month() != day.Month()
+		}
+		return false
+	case "DayOfMonth":
+		//A DayOfMonth past the end of the month (e.g. 31st in April) runs on the last day of the month
+		lastDay := time.Date(day.Year(), day.Month()+1, 0, 0, 0, 0, 0, day.Location()).Day()
+		return day.Day() == s.DayOfMonth || (s.DayOfMonth > lastDay && day.Day() == lastDay)
+	}
+	return false
+}
+
+//Get the times that the schedule runs at on a day that it runs on
+func (s cbbSchedule) runsDuring(day time.Time) []time.Time {
+	if !s.DailyRecurrence || s.DailyRecurrencePeriod <= 0 {
+		return []time.Time{day.Add(time.Duration(s.Hour)*time.Hour + time.Duration(s.Minutes)*time.Minute + time.Duration(s.Seconds)*time.Second)}
+	}
+	var runs []time.Time
+	for offset := s.DailyFrom; offset <= s.DailyTill && offset < 24*time.Hour; offset += time.Duration(s.DailyRecurrencePeriod) * time.Minute {
+		runs = append(runs, day.Add(offset))
+	}
+	return runs
+}
+
+//The plan XML is written by .NET, so booleans are "true" and "false"
+func cbbBool(v string) bool {
+	return strings.EqualFold(strings.TrimSpace(v), "true")
+}
+
+//Read a number from the plan XML, or 0 if it isn't a number
+func cbbInt(v string) int {
+	i, _ := strconv.Atoi(strings.TrimSpace(v))
+	return i
+}
+
+//.NET TimeSpans are written as a number of 100ns ticks. TimeSpan.MaxValue (and anything else too big for a
+//time.Duration) is used to mean there is no limit, which we return as 0.
+func ticksToDuration(ticks string) time.Duration {
+	t, err := strconv.ParseInt(strings.TrimSpace(ticks), 10, 64)
+	if err != nil || t <= 0 || t > math.MaxInt64/100 {
+		return 0
+	}
+	return time.Duration(t * 100)
+}
+
+//Read a .NET DayOfWeek name
+func cbbWeekday(v string) (time.Weekday, bool) {
+	v = strings.TrimSpace(v)
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(v, d.String()) {
+			return d, true
+		}
+	}
+	return time.Sunday, false
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func localTime(year int, month time.Month, day, hour, min int) time.Time {
+	return time.Date(year, month, day, hour, min, 0, 0, time.Local)
+}
+
+func TestScheduleNext(t *testing.T) {
+	//2026-01-05 is a Monday
+	everyOtherWeek := cbbSchedule{Enabled: true, RecurType: "Weekly", RepeatEvery: 2, Hour: 2, WeekDays: []time.Weekday{time.Monday, time.Tuesday}}
+	weekly := cbbSchedule{Enabled: true, RecurType: "Weekly", RepeatEvery: 1, Hour: 2, Minutes: 30, WeekDays: []time.Weekday{time.Monday, time.Thursday}}
+	everyThreeDays := cbbSchedule{Enabled: true, RecurType: "Daily", RepeatEvery: 3, Hour: 22}
+	hourly := cbbSchedule{Enabled: true, RecurType: "Daily", RepeatEvery: 2, DailyRecurrence: true, DailyRecurrencePeriod: 60, DailyFrom: 8 * time.Hour, DailyTill: 18 * time.Hour}
+	dayOfMonth := cbbSchedule{Enabled: true, RecurType: "DayOfMonth", RepeatEvery: 2, Hour: 1, DayOfMonth: 31}
+	lastFriday := cbbSchedule{Enabled: true, RecurType: "Monthly", RepeatEvery: 1, Hour: 23, DayOfWeek: time.Friday, WeekNumber: "Last"}
+
+	tests := []struct {
+		name     string
+		schedule cbbSchedule
+		after    time.Time
+		expected time.Time
+	}{
+		{"every 2 weeks, the rest of this week first", everyOtherWeek, localTime(2026, 1, 5, 2, 0), localTime(2026, 1, 6, 2, 0)},
+		{"every 2 weeks, then 2 weeks on", everyOtherWeek, localTime(2026, 1, 6, 2, 0), localTime(2026, 1, 19, 2, 0)},
+		{"every 2 weeks, from before the first run", everyOtherWeek, localTime(2026, 1, 5, 1, 0), localTime(2026, 1, 5, 2, 0)},
+		{"weekly, later in the week", weekly, localTime(2026, 1, 5, 2, 30), localTime(2026, 1, 8, 2, 30)},
+		{"weekly, next week", weekly, localTime(2026, 1, 8, 2, 30), localTime(2026, 1, 12, 2, 30)},
+		{"every 3 days", everyThreeDays, localTime(2026, 1, 5, 22, 0), localTime(2026, 1, 8, 22, 0)},
+		{"every 3 days, later the same day", everyThreeDays, localTime(2026, 1, 5, 9, 0), localTime(2026, 1, 5, 22, 0)},
+		{"recurring during the day", hourly, localTime(2026, 1, 5, 8, 0), localTime(2026, 1, 5, 9, 0)},
+		{"recurring, last run of the day", hourly, localTime(2026, 1, 5, 18, 0), localTime(2026, 1, 7, 8, 0)},
+		{"day 31 in a short month", dayOfMonth, localTime(2026, 1, 31, 1, 0), localTime(2026, 3, 31, 1, 0)},
+		{"day 31 runs on the last day", dayOfMonth, localTime(2026, 4, 1, 0, 0), localTime(2026, 4, 30, 1, 0)},
+		{"last Friday", lastFriday, localTime(2026, 1, 30, 23, 0), localTime(2026, 2, 27, 23, 0)},
+	}
+	for _, test := range tests {
+		got, ok := test.schedule.next(test.after)
+		if !ok || !got.Equal(test.expected) {
+			t.Errorf("%s: next(%v) = %v, %v, expected %v", test.name, test.after, got, ok, test.expected)
+		}
+	}
+}
+
+func TestScheduleNextNever(t *testing.T) {
+	once := cbbSchedule{Enabled: true, RecurType: "Once", OnceDate: localTime(2026, 1, 5, 2, 0)}
+	for name, s := range map[string]cbbSchedule{
+		"disabled":      {RecurType: "Daily", RepeatEvery: 1},
+		"unknown type":  {Enabled: true, RecurType: "Fortnightly", RepeatEvery: 1},
+		"no week days":  {Enabled: true, RecurType: "Weekly", RepeatEvery: 1},
+		"once, already": once,
+	} {
+		if got, ok := s.next(localTime(2026, 1, 6, 0, 0)); ok {
+			t.Errorf("%s: expected no next run, got %v", name, got)
+		}
+	}
+	if got, ok := once.next(localTime(2026, 1, 1, 0, 0)); !ok || !got.Equal(once.OnceDate) {
+		t.Errorf("once: got %v, %v, expected %v", got, ok, once.OnceDate)
+	}
+}
+
+func TestScheduleInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule cbbSchedule
+		expected time.Duration
+	}{
+		{"daily", cbbSchedule{Enabled: true, RecurType: "Daily", RepeatEvery: 1, Hour: 22}, 24 * time.Hour},
+		{"Monday and Thursday", cbbSchedule{Enabled: true, RecurType: "Weekly", RepeatEvery: 1, WeekDays: []time.Weekday{time.Monday, time.Thursday}}, 4 * 24 * time.Hour},
+		{"Monday and Tuesday every 2 weeks", cbbSchedule{Enabled: true, RecurType: "Weekly", RepeatEvery: 2, WeekDays: []time.Weekday{time.Monday, time.Tuesday}}, 13 * 24 * time.Hour},
+	}
+	for _, test := range tests {
+		//DST changes can make a gap an hour longer
+		if got := test.schedule.interval(); got < test.expected || got > test.expected+time.Hour {
+			t.Errorf("%s: interval() = %v, expected %v", test.name, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+	"strings"
+	"time"
+
+	"bosun.org/opentsdb"
+)
+
+//Ransomware tends to show up in the backup log well before anyone notices it on the file server: a huge
+//proportion of the backup set gets modified in one go, files start appearing with extensions like .locked,
+//and the mix of extensions changes completely from one run to the next. These are the indicators that we
+//measure for the most recent session of each plan.
+type ransomwareIndicators struct {
+	Files         int     //Number of file operations recorded in the session
+	Modified      int     //Files that were backed up because they were modified since the previous session
+	Suspicious    int     //Files that have an extension from the suspicious extension list
+	NewExtension  int     //Files whose extension did not appear at all in the last session that had files
+	ModifiedRatio float64 //Modified files as a fraction of the whole backup set
+	Churn         float64 //NewExtension as a fraction of the files in the session
+	HasChurn      bool    //Whether there was an earlier session with files to measure the churn against
+	RiskScore     float64 //A 0-100 score made up from the indicators above
+
+	suspicious         map[string]bool //Lookup table of suspicious extensions
+	previousExtensions map[string]bool //Every extension seen in the last session that had files
+	modifiedSince      time.Time       //Files modified after this time count as modified
+}
+
+//Get ready to work out the ransomware indicators for a session, by loading the extensions of the files in the
+//last session of the same plan that had any files. An incremental run with nothing to back up has no files, and
+//comparing with it would make every extension look new. previous is the session before this one, and can be nil if
+//this is the first time the plan has run. Each file in the session then needs to be passed to addFile, followed by
+//a call to finish.
+func newRansomwareIndicators(db *sql.DB, session cbbSessionHistoryRow, previous *cbbSessionHistoryRow, suspicious map[string]bool) (*ransomwareIndicators, error) {
+	r := &ransomwareIndicators{
+		suspicious:         suspicious,
+		previousExtensions: make(map[string]bool),
+	}
+	if previous == nil {
+		return r, nil
+	}
+
+	//Build up the set of extensions that were seen last time there were any files, so that we can tell which ones
+	//are new
+	baseline, found, err := lastSessionWithFiles(db, session.PlanID, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		r.HasChurn = true
+		err = eachSessionFile(db, session.PlanID, baseline, func(file cbbHistoryRow) {
+			r.previousExtensions[fileExtension(file.LocalPath)] = true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	//A file only counts as modified if it was changed after the previous session started. Without a previous
+	//session (or with a start time we can't read) every backed up file counts.
+	if started, err := cbbTimeToTime(previous.DateStartUtc); err == nil {
+		r.modifiedSince = started
+	}
+	return r, nil
+}
+
+//Count a single file from the session towards the indicators
+func (r *ransomwareIndicators) addFile(file cbbHistoryRow) {
+	r.Files++
+	ext := fileExtension(file.LocalPath)
+	if r.suspicious[ext] {
+		r.Suspicious++
+	}
+	if r.HasChurn && !r.previousExtensions[ext] {
+		r.NewExtension++
+	}
+	//A file with a modified time that we can't read was still backed up, so it counts
+	if file.Operation == 1 {
+		if modified, err := cbbTimeToTime(file.DateModifiedUtc); err != nil || !modified.Before(r.modifiedSince) {
+			r.Modified++
+		}
+	}
+}
+
+//Work out the ratios and the risk score once all of the files in the session have been added
+func (r *ransomwareIndicators) finish(session cbbSessionHistoryRow) {
+	if session.TotalCount > 0 {
+		r.ModifiedRatio = math.Min(1, float64(r.Modified)/float64(session.TotalCount))
+	}
+	if r.Files > 0 {
+		r.Churn = float64(r.NewExtension) / float64(r.Files)
+	}
+
+	//Suspicious extensions are the strongest signal we have, so they carry the most weight. A session where 10% or
+	//more of the files have a suspicious extension maxes out that part of the score. Without any churn to go on, the
+	//score is made up from the other two, in the same proportions.
+	suspiciousScore := 0.0
+	if r.Files > 0 {
+		suspiciousScore = math.Min(1, float64(r.Suspicious)/float64(r.Files)*10)
+	}
+	score, weight := 0.3*r.ModifiedRatio+0.4*suspiciousScore, 0.7
+	if r.HasChurn {
+		score, weight = score+0.3*r.Churn, 1
+	}
+	r.RiskScore = math.Floor(100 * score / weight)
+}
+
+//Send the ransomware indicators for the latest session of a plan to Bosun
+func sendRansomwareIndicators(r *ransomwareIndicators, planName string) {
+	bosunDataPoint("cloudberry.security.suspicious_files", r.Suspicious, opentsdb.TagSet{"job": planName})
+	bosunDataPoint("cloudberry.security.modified_ratio", r.ModifiedRatio, opentsdb.TagSet{"job": planName})
+	if r.HasChurn {
+		bosunDataPoint("cloudberry.security.extension_churn", r.Churn, opentsdb.TagSet{"job": planName})
+	}
+	bosunDataPoint("cloudberry.security.risk_score", r.RiskScore, opentsdb.TagSet{"job": planName})
+}
+
+//Get the lower case extension of a file, including the period. The paths in the history table are Windows
+//paths, so we can't rely on filepath.Ext to find the separator when this is built for another platform.
+func fileExtension(path string) string {
+	name := path[strings.LastIndexAny(path, "\\/")+1:]
+	if i := strings.LastIndex(name, "."); i > 0 {
+		return strings.ToLower(name[i:])
+	}
+	return ""
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRansomwareIndicators(t *testing.T) {
+	started := time.Date(2026, 1, 1, 22, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	documents := []testFile{{path: `C:\data\a.docx`}, {path: `C:\data\b.xlsx`}, {path: `C:\data\c.docx`}, {path: `C:\data\d.pdf`}}
+	encrypted := []testFile{{path: `C:\data\a.docx.locked`}, {path: `C:\data\b.xlsx.locked`}, {path: `C:\data\c.docx`}, {path: `C:\data\d.pdf`}}
+	planID := "11111111-aaaa"
+
+	path := filepath.Join(t.TempDir(), "cbbackup.db")
+	writeTestDB(t, path, []testSession{
+		{id: 1, planID: planID, started: started, result: cbbResultSuccess, files: documents},
+		{id: 2, planID: planID, started: started.Add(day), result: cbbResultSuccess}, //Nothing changed, so no files
+		{id: 3, planID: planID, started: started.Add(2 * day), result: cbbResultSuccess, files: documents},
+		{id: 4, planID: planID, started: started.Add(3 * day), result: cbbResultSuccess, files: encrypted},
+		{id: 5, planID: "22222222-bbbb", started: started, result: cbbResultSuccess},
+		{id: 6, planID: "22222222-bbbb", started: started.Add(day), result: cbbResultSuccess, files: documents},
+	})
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	sessions := make(map[int]cbbSessionHistoryRow)
+	for _, planID := range []string{planID, "22222222-bbbb"} {
+		planSessions, err := planSessions(db, planID, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range planSessions {
+			sessions[s.ID] = s
+		}
+	}
+	suspicious := parseExtensionList(".locked")
+
+	tests := []struct {
+		name       string
+		session    int
+		previous   int
+		hasChurn   bool
+		churn      float64
+		suspicious int
+	}{
+		{"first run", 1, 0, false, 0, 0},
+		{"after a run with no files", 3, 2, true, 0, 0},
+		{"encrypted", 4, 3, true, 0.5, 2},
+		{"nothing earlier had files", 6, 5, false, 0, 0},
+	}
+	for _, test := range tests {
+		var previous *cbbSessionHistoryRow
+		if test.previous != 0 {
+			p := sessions[test.previous]
+			previous = &p
+		}
+		session := sessions[test.session]
+		r, err := newRansomwareIndicators(db, session, previous, suspicious)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		err = eachSessionFile(db, session.PlanID, session.ID, r.addFile)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		r.finish(session)
+		if r.HasChurn != test.hasChurn || r.Churn != test.churn || r.Suspicious != test.suspicious {
+			t.Errorf("%s: got churn %v %v and %d suspicious, expected churn %v %v and %d suspicious", test.name, r.HasChurn, r.Churn, r.Suspicious, test.hasChurn, test.churn, test.suspicious)
+		}
+		if test.suspicious == 0 && r.RiskScore > 30 {
+			t.Errorf("%s: risk score %v with nothing suspicious", test.name, r.RiskScore)
+		}
+	}
+}
+
+func TestRansomwareRiskScore(t *testing.T) {
+	session := cbbSessionHistoryRow{TotalCount: 100}
+	tests := []struct {
+		name     string
+		r        ransomwareIndicators
+		expected float64
+	}{
+		{"quiet", ransomwareIndicators{Files: 10, Modified: 5, HasChurn: true}, 1},
+		{"everything", ransomwareIndicators{Files: 100, Modified: 100, Suspicious: 100, NewExtension: 100, HasChurn: true}, 100},
+		{"everything, no churn", ransomwareIndicators{Files: 100, Modified: 100, Suspicious: 100}, 100},
+		{"suspicious only, no churn", ransomwareIndicators{Files: 100, Suspicious: 10}, 57},
+	}
+	for _, test := range tests {
+		test.r.finish(session)
+		if test.r.RiskScore != test.expected {
+			t.Errorf("%s: risk score %v, expected %v", test.name, test.r.RiskScore, test.expected)
+		}
+	}
+}
+
+func TestFileExtension(t *testing.T) {
+	for path, expected := range map[string]string{
+		`C:\data\Report.DOCX`:     ".docx",
+		`C:\data\archive.tar.gz`:  ".gz",
+		`C:\data.d\Makefile`:      "",
+		`/home/user/.bashrc`:      "",
+		`C:\data\a.docx.locked`:   ".locked",
+		`\\server\share\file.txt`: ".txt",
+	} {
+		if got := fileExtension(path); got != expected {
+			t.Errorf("fileExtension(%q) = %q, expected %q", path, got, expected)
+		}
+	}
+}
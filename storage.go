@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bosun.org/opentsdb"
+)
+
+//The storage classes that a plan can write to. cloudberry.plan.storage_class is sent as the position in this list,
+//so only ever add to the end of it.
+var cbbStorageClasses = []string{"standard", "standard_ia", "reduced_redundancy", "archive"}
+
+//Work out which storage class a plan writes to
+func planStorageClass(plan cbbBasePlan) string {
+	switch {
+	case cbbBool(plan.IsArchive):
+		return "archive"
+	case cbbBool(plan.UseRRS):
+		return "reduced_redundancy"
+	case cbbBool(plan.UseStandardIA):
+		return "standard_ia"
+	}
+	return "standard"
+}
+
+//Work out how a plan writes its backups. Simple plans keep a plain copy of each file with no versions, block level
+//plans only upload the parts of a file that have changed since the last run, and everything else uploads whole
+//files.
+func planBackupFormat(plan cbbBasePlan) string {
+	switch {
+	case cbbBool(plan.IsSimple):
+		return "simple"
+	case cbbBool(plan.UseDifferentialUpload):
+		return "block_level"
+	}
+	return "file"
+}
+
+//The tags for the job metrics that are worth breaking down by where and how the data is stored
+func storageTags(plan cbbBasePlan) opentsdb.TagSet {
+	return opentsdb.TagSet{"job": plan.Name, "storage_class": planStorageClass(plan), "backup_format": planBackupFormat(plan)}
+}
+
+//Send the storage class that a plan writes to
+func sendStorageSettings(plan cbbBasePlan) {
+	class := planStorageClass(plan)
+	for i, c := range cbbStorageClasses {
+		if c == class {
+			bosunDataPoint("cloudberry.plan.storage_class", i, opentsdb.TagSet{"job": plan.Name})
+		}
+	}
+	bosunMetadata("cloudberry.plan.storage_class", "storage_class", class, opentsdb.TagSet{"job": plan.Name})
+	bosunMetadata("cloudberry.plan.storage_class", "backup_format", planBackupFormat(plan), opentsdb.TagSet{"job": plan.Name})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+
+	"bosun.org/opentsdb"
+)
+
+//Sending every file in a session to Bosun is far too much data, but a handful of files are usually responsible
+//for most of the size and time of a backup. This keeps track of the N largest and N slowest files in a session,
+//plus the number of files that needed more than one attempt.
+type sessionTopFiles struct {
+	N       int             //How many files to keep in each list
+	Largest []cbbHistoryRow //The largest files in the session, largest first
+	Slowest []cbbHistoryRow //The slowest files in the session, slowest first
+	Retried int             //Number of files that took more than one attempt
+}
+
+func newSessionTopFiles(n int) *sessionTopFiles {
+	return &sessionTopFiles{N: n}
+}
+
+//Orderings for the top N lists
+func largerFile(a, b cbbHistoryRow) bool { return a.Size > b.Size }
+func slowerFile(a, b cbbHistoryRow) bool { return a.Duration > b.Duration }
+
+//Count a single file from the session towards the top N lists
+func (t *sessionTopFiles) addFile(file cbbHistoryRow) {
+	if file.Attempts > 1 {
+		t.Retried++
+	}
+	t.Largest = insertTopFile(t.Largest, file, t.N, largerFile)
+	t.Slowest = insertTopFile(t.Slowest, file, t.N, slowerFile)
+}
+
+//Insert a file in to a sorted list, keeping only the first n. Files that tie with one already in the list go
+//after it, so the earliest file to finish wins.
+func insertTopFile(list []cbbHistoryRow, file cbbHistoryRow, n int, less func(a, b cbbHistoryRow) bool) []cbbHistoryRow {
+	if n <= 0 {
+		return list
+	}
+	i := sort.Search(len(list), func(i int) bool { return less(file, list[i]) })
+	if i >= n {
+		return list
+	}
+	list = append(list, cbbHistoryRow{})
+	copy(list[i+1:], list[i:])
+	list[i] = file
+	if len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+//Send the top N lists for the latest session of a plan to Bosun. We always send N ranks, with zeros for the
+//ranks we don't have a file for, so that the number of series stays the same from one run to the next. The
+//path to each file is sent as metadata, as it's far too varied to use as a tag.
+func sendSessionTopFiles(t *sessionTopFiles, planName string) {
+	for i := 0; i < t.N; i++ {
+		rank := strconv.Itoa(i + 1)
+
+		var size float32
+		if i < len(t.Largest) {
+			size = t.Largest[i].Size
+			bosunMetadata("cloudberry.job.largest_files", "path", t.Largest[i].LocalPath, opentsdb.TagSet{"job": planName, "rank": rank})
+		}
+		bosunDataPoint("cloudberry.job.largest_files", size, opentsdb.TagSet{"job": planName, "rank": rank})
+
+		var duration int
+		if i < len(t.Slowest) {
+			duration = t.Slowest[i].Duration
+			bosunMetadata("cloudberry.job.slowest_files", "path", t.Slowest[i].LocalPath, opentsdb.TagSet{"job": planName, "rank": rank})
+		}
+		bosunDataPoint("cloudberry.job.slowest_files", duration, opentsdb.TagSet{"job": planName, "rank": rank})
+	}
+	bosunDataPoint("cloudberry.job.files_retried", t.Retried, opentsdb.TagSet{"job": planName})
+}
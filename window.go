@@ -0,0 +1,48 @@
+package main
+
+import (
+	"time"
+
+	"bosun.org/opentsdb"
+)
+
+//A run that ends within this long of the plan's stop after limit is counted as having been stopped by it
+const stopAfterTolerance = time.Minute
+
+//Work out how long a session was allowed to run for. If the plan has a stop after limit then that is the window,
+//otherwise the window is the time until the next scheduled run, as that is when the next run would start
+//overlapping this one. Returns 0 if the plan doesn't run on a schedule that we understand, or if we don't know when
+//the session started.
+func allowedWindow(plan cbbBasePlan, started time.Time) time.Duration {
+	schedule := plan.schedule()
+	if schedule.StopAfter > 0 {
+		return schedule.StopAfter
+	}
+	if started.IsZero() {
+		return 0
+	}
+	if next, ok := schedule.next(started); ok {
+		return next.Sub(started)
+	}
+	return 0
+}
+
+//Send the throughput of the latest session of a plan, and how much of the plan's backup window it used
+func sendWindowMetrics(plan cbbBasePlan, session cbbSessionHistoryRow, started time.Time) {
+	if session.Duration > 0 {
+		bosunDataPoint("cloudberry.job.throughput_bytes_per_sec", float64(session.UploadedSize)/float64(session.Duration), opentsdb.TagSet{"job": plan.Name})
+	}
+
+	timeTaken := time.Duration(session.Duration) * time.Second
+	if window := allowedWindow(plan, started); window > 0 {
+		bosunDataPoint("cloudberry.job.window_utilisation", timeTaken.Seconds()/window.Seconds(), opentsdb.TagSet{"job": plan.Name})
+	}
+
+	//CloudBerry doesn't record why a run ended, so a run that didn't succeed and went on for as long as the stop
+	//after limit is assumed to have been stopped by it.
+	stopped := 0
+	if stopAfter := plan.schedule().StopAfter; stopAfter > 0 && isFailedSession(session) && timeTaken >= stopAfter-stopAfterTolerance {
+		stopped = 1
+	}
+	bosunDataPoint("cloudberry.job.stopped_by_window", stopped, opentsdb.TagSet{"job": plan.Name})
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"bosun.org/opentsdb"
+)
+
+func TestStoppedByWindow(t *testing.T) {
+	plan := cbbBasePlan{Name: "Nightly", StopAfterTicksSchedule: "36000000000"} //An hour
+	started := time.Date(2026, 1, 1, 22, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		result   int
+		duration int
+		expected int
+	}{
+		{"failed at the limit", 1, 3600, 1},
+		{"failed before the limit", 1, 1800, 0},
+		{"succeeded at the limit", cbbResultSuccess, 3600, 0},
+		{"still running at the limit", cbbResultRunning, 3600, 0}, //It hasn't been stopped yet
+	}
+	for _, test := range tests {
+		out, _ := useTestCollector(t, t.TempDir(), t.TempDir())
+		output = out
+		sendWindowMetrics(plan, cbbSessionHistoryRow{Result: test.result, Duration: test.duration}, started)
+
+		var stopped []opentsdb.DataPoint
+		for _, dp := range out.points {
+			if dp.Metric == "cloudberry.job.stopped_by_window" {
+				stopped = append(stopped, dp)
+			}
+		}
+		if len(stopped) != 1 || stopped[0].Value != test.expected {
+			t.Errorf("%s: expected stopped_by_window %d, got %v", test.name, test.expected, stopped)
+		}
+	}
+}